@@ -1,35 +1,35 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
+	"flag"
 	"fmt"
-	"io"
-	"log"
 	"os"
-)
-
-// Compute the sha1 hash of a file
-func sha1sum(filename string) string {
-	f, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
 
-	h := sha1.New()
-	if _, err := io.Copy(h, f); err != nil {
-		log.Fatal(err)
-	}
+	"RSKGroup/OPIe/utils/fileHash"
+)
 
-	return hex.EncodeToString(h.Sum(nil))
+// Compute the hash of a file with the given algorithm
+func hashsum(algo fileHash.Algorithm, filename string) (string, error) {
+	return algo.File(filename)
 }
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Printf("Usage: %s <filename>\n", os.Args[0])
+	algoName := flag.String("algo", "sha1", "hash algorithm: sha1, sha256, blake3 or xxhash")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Printf("Usage: %s [-algo name] <filename>\n", os.Args[0])
 		os.Exit(1)
 	}
 
-	fmt.Println(sha1sum(os.Args[1]))
+	algo, err := fileHash.ParseAlgorithm(*algoName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	sum, err := hashsum(algo, flag.Arg(0))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println(sum)
 }
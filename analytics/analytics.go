@@ -104,8 +104,16 @@ func sumFieldWithSubstring(fieldName, substring string) (float64, error) {
 			return 0, err
 		}
 
-		total := result["total"].(float64)
-		return total, nil
+		// FileSizeRaw is stored as a native integer, so the total is too
+		switch total := result["total"].(type) {
+		case int32:
+			return float64(total), nil
+		case int64:
+			return float64(total), nil
+		case float64:
+			return total, nil
+		}
+		return 0, nil
 	}
 
 	// No matching documents found
@@ -2,24 +2,23 @@ package main
 
 import (
 	"context"
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"reflect"
-	"strconv"
 	"strings"
+	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"RSKGroup/OPIe/utils/documentId"
+	"RSKGroup/OPIe/utils/fileHash"
+	"RSKGroup/OPIe/utils/fileType"
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"RSKGroup/OPIe/utils/symlink"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Config struct {
@@ -36,6 +35,9 @@ type Config struct {
 	Watcher       []string `json:"Watcher"`
 	Root          string   `json:"root"`
 	Path          string   `json:"path"`
+	IdStrategy    string   `json:"IdStrategy"`
+	HashAlgo      string   `json:"HashAlgo"`
+	PathHashAlgo  string   `json:"PathHashAlgo"`
 }
 
 var config *string
@@ -43,6 +45,10 @@ var path *string
 var root *string
 var watcher *bool
 var fileCollection *mongo.Collection
+var idStrategy documentId.Strategy
+var hashAlgo fileHash.Algorithm
+var pathHashAlgo fileHash.Algorithm
+var noExif = make(map[string]bool)
 
 // init() variables and use the default cinfiguration. Note, the conf.json file must
 // exist in the same directory as the builder executable
@@ -67,6 +73,24 @@ func main() {
 		fmt.Printf("Failed to read configuration file: %v\n", err)
 		return
 	}
+	idStrategy, err = documentId.ParseStrategy(config.IdStrategy)
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		return
+	}
+	hashAlgo, err = fileHash.ParseAlgorithm(config.HashAlgo)
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		return
+	}
+	pathHashAlgo, err = fileHash.ParseAlgorithm(config.PathHashAlgo)
+	if err != nil {
+		fmt.Printf("Invalid configuration: %v\n", err)
+		return
+	}
+	for _, ext := range config.NoExif {
+		noExif[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
 	pathValue := *path
 	rootValue := *root
 	// If root is not passed, we must assume that the path is the root
@@ -145,6 +169,10 @@ func processPath(collection *mongo.Collection, pathValue, rootValue string, watc
 // // Determine file type and do both compileXData and saveDataToDB
 func runCompileAndWrite(collection *mongo.Collection, pathValue, rootValue string, watcherValue bool, fileInfo os.FileInfo) error {
 	dataInfo, err := compileData(pathValue, rootValue, fileInfo)
+	if err != nil {
+		fmt.Println("Failed to compile data: ", err)
+		return err
+	}
 	// Save the directory data to MongoDB
 	err = saveDataToDB(collection, dataInfo)
 	if err != nil {
@@ -156,146 +184,91 @@ func runCompileAndWrite(collection *mongo.Collection, pathValue, rootValue strin
 }
 
 // Compile directory or file data
-func compileData(pathValue, rootValue string, fileInfo os.FileInfo) (map[string]string, error) {
+func compileData(pathValue, rootValue string, fileInfo os.FileInfo) (mongoWrite.Document, error) {
+	now := time.Now()
+	paths := ancestryPaths(pathValue, rootValue)
+	base := mongoWrite.BaseRecord{
+		SourceFile:         pathValue,
+		DirectoryName:      filepath.Dir(pathValue),
+		FileName:           fileInfo.Name(),
+		FileSizeRaw:        fileInfo.Size(),
+		FileMode:           fileInfo.Mode().String(),
+		FileModTime:        fileInfo.ModTime(),
+		SourcePathHash:     computeStringHash(pathValue),
+		DirectoryHash:      computeStringHash(filepath.Dir(pathValue)),
+		AncestryPaths:      paths,
+		AncestryPathHashes: ancestryPathHashes(paths),
+		IndexTime:          now,
+		LastSeenTime:       now,
+		IsDirectory:        fileInfo.IsDir(),
+	}
+
 	if isSymbolicLink(fileInfo) {
-		// For symlinks, handle symlink data
-		linkPath, err := os.Readlink(pathValue)
+		// For symlinks, record where the chain of links ends up
+		link, err := symlink.Resolve(pathValue)
 		if err != nil {
 			return nil, err
 		}
-		symlinkIsDir := ""
-		if fileInfo.IsDir() {
-			symlinkIsDir = "true"
-		} else {
-			symlinkIsDir = "false"
-		}
-
-		symlinkInfo := map[string]string{
-			"_id":                computeStringHash(pathValue),
-			"SourceFile":         pathValue,
-			"DirectoryName":      filepath.Dir(pathValue),
-			"FileName":           fileInfo.Name(),
-			"FileSizeRaw":        strconv.FormatInt(fileInfo.Size(), 10),
-			"FileMode":           fileInfo.Mode().String(),
-			"FileModTime":        fileInfo.ModTime().Format("2006-01-02 15:04:05"),
-			"SourcePathHash":     computeStringHash(pathValue),
-			"DirectoryHash":      computeStringHash(filepath.Dir(pathValue)),
-			"AncestryPaths":      strings.Join(ancestryPaths(pathValue, rootValue), ", "),
-			"AncestryPathHashes": strings.Join(ancestryPathHashes(ancestryPaths(pathValue, rootValue)), ", "),
-			"IsDirectory":        symlinkIsDir,
-			"IsSymLink":          "true",
-			"SymlinkDestination": linkPath,
-		}
-		return symlinkInfo, nil
+		base.ID = idStrategy.ForPath(base.SourcePathHash)
+		base.IsSymLink = true
+
+		return mongoWrite.ToDocument(mongoWrite.SymlinkRecord{
+			BaseRecord: base,
+			LinkFields: mongoWrite.LinkFields{
+				SymlinkDestination: link.Destination,
+				SymlinkChain:       link.Chain,
+				SymlinkTarget:      link.Target,
+				SymlinkBroken:      link.Broken,
+				SymlinkLoop:        link.Loop,
+			},
+		})
 	} else if fileInfo.IsDir() {
 		// For directories, compile directory data
-		dirInfo := map[string]string{
-			"_id":                computeStringHash(pathValue),
-			"SourceFile":         pathValue,
-			"DirectoryName":      filepath.Dir(pathValue),
-			"FileName":           fileInfo.Name(),
-			"FileSizeRaw":        strconv.FormatInt(fileInfo.Size(), 10),
-			"FileMode":           fileInfo.Mode().String(),
-			"FileModTime":        fileInfo.ModTime().Format("2006-01-02 15:04:05"),
-			"SourcePathHash":     computeStringHash(pathValue),
-			"DirectoryHash":      computeStringHash(filepath.Dir(pathValue)),
-			"AncestryPaths":      strings.Join(ancestryPaths(pathValue, rootValue), ", "),
-			"AncestryPathHashes": strings.Join(ancestryPathHashes(ancestryPaths(pathValue, rootValue)), ", "),
-			"IsDirectory":        "true",
-		}
-		return dirInfo, nil
+		base.ID = idStrategy.ForPath(base.SourcePathHash)
+		return mongoWrite.ToDocument(base)
 	} else {
-		// For files, compile file data
-		exifData, err := readExifData(pathValue)
+		// For files, compile file data, with exif data when it is available
+		// and the extension isn't listed in NoExif
+		var exifData map[string]interface{}
+		if !noExif[strings.ToLower(strings.TrimPrefix(filepath.Ext(pathValue), "."))] {
+			exifData, _ = readExifData(pathValue)
+		}
+		// A file that can't be hashed is still indexed, with the error
+		var hashError string
+		hash, err := computeFileHash(pathValue)
 		if err != nil {
-			// If exif data is not available, compile data without exif
-			fileInfo := map[string]string{
-				"_id":                computeStringHash(pathValue),
-				"SourceFile":         pathValue,
-				"DirectoryName":      filepath.Dir(pathValue),
-				"FileName":           fileInfo.Name(),
-				"FileSizeRaw":        strconv.FormatInt(fileInfo.Size(), 10),
-				"FileMode":           fileInfo.Mode().String(),
-				"FileModTime":        fileInfo.ModTime().Format("2006-01-02 15:04:05"),
-				"IsDirectory":        "false",
-				"SourcePathHash":     computeStringHash(pathValue),
-				"DirectoryHash":      computeStringHash(filepath.Dir(pathValue)),
-				"AncestryPaths":      strings.Join(ancestryPaths(pathValue, rootValue), ", "),
-				"AncestryPathHashes": strings.Join(ancestryPathHashes(ancestryPaths(pathValue, rootValue)), ", "),
-				"FileHash":           computeFileHash(pathValue),
-			}
-			return fileInfo, nil
+			log.Printf("Failed to hash %s: %v\n", pathValue, err)
+			hashError = err.Error()
+		}
+		base.ID = idStrategy.ForFile(base.SourcePathHash, hash)
+		mimeType, err := fileType.Detect(pathValue)
+		if err != nil {
+			mimeType = fileType.FromExtension(filepath.Ext(pathValue))
 		}
 
-		// Add additional file information to exif data
-		exifData["_id"] = computeStringHash(pathValue)
-		exifData["SourcePathHash"] = computeStringHash(pathValue)
-		exifData["DirectoryHash"] = computeStringHash(filepath.Dir(pathValue))
-		exifData["FileHash"] = computeFileHash(pathValue)
-		exifData["FileSizeRaw"] = strconv.FormatInt(fileInfo.Size(), 10)
-		exifData["FileMode"] = fileInfo.Mode().String()
-		exifData["FileModTime"] = fileInfo.ModTime().Format("2006-01-02 15:04:05")
-		exifData["AncestryPaths"] = strings.Join(ancestryPaths(pathValue, rootValue), ", ")
-		exifData["AncestryPathHashes"] = strings.Join(ancestryPathHashes(ancestryPaths(pathValue, rootValue)), ", ")
-		exifData["FileTypeExtension"] = filepath.Ext(fileInfo.Name())
-		exifData["IsDirectory"] = "false"
-
-		return exifData, nil
+		return mongoWrite.ToDocument(mongoWrite.FileRecord{
+			BaseRecord:        base,
+			FileHash:          hash,
+			FileHashAlgo:      hashAlgo.String(),
+			HashError:         hashError,
+			FileTypeExtension: filepath.Ext(fileInfo.Name()),
+			MIMEType:          mimeType,
+			FileCategory:      fileType.Category(mimeType, filepath.Ext(fileInfo.Name())),
+			Exif:              exifData,
+		})
 	}
 }
 
 // DATABASE FUNCTIONS
 // Connect to MongoDB and return the collection
 func connectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName, collectionName string) (*mongo.Collection, error) {
-	// Construct MongoDB connection URI
-	mongodbURI := dbType + "://" + dbUser + ":" + dbPwd + "@" + host + ":" + port
-
-	// Configure the client connection
-	clientOptions := options.Client().ApplyURI(mongodbURI)
-
-	// Connect to MongoDB
-	client, err := mongo.Connect(context.Background(), clientOptions)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
-	}
-
-	// Check if the connection was successful
-	err = client.Ping(context.Background(), nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to ping MongoDB: %v", err)
-	}
-
-	// Access the specified database and collection
-	db := client.Database(dbName)
-	collection := db.Collection(collectionName)
-
-	return collection, nil
+	_, collection, err := mongoWrite.ConnectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName, collectionName)
+	return collection, err
 }
 
 // Save data to MongoDB
-func saveDataToDB(collection *mongo.Collection, data map[string]string) error {
-	// Convert the data map to BSON
-	doc := bson.M{}
-	for k, v := range data {
-		doc[k] = v
-	}
-
-	// Set the filter to check if the document with the given _id already exists
-	filter := bson.M{"_id": doc["_id"]}
-
-	// Set the update to replace the existing document with the new data
-	update := bson.M{"$set": doc}
-
-	// Set the options for upsert (create if not exists)
-	options := options.Update().SetUpsert(true)
-
-	// Perform the upsert operation in MongoDB
-	_, err := collection.UpdateOne(context.Background(), filter, update, options)
-	if err != nil {
-		return err
-	}
-
-	return nil
+func saveDataToDB(collection *mongo.Collection, data mongoWrite.Document) error {
+	return mongoWrite.UpsertDocument(context.Background(), collection, data)
 }
 
 // UTILITY FUNCTIONS
@@ -309,33 +282,24 @@ func readFileInfo(filePath string) (os.FileInfo, error) {
 	return fileInfo, nil
 }
 
-// Read a file's exif data and then flatten it using the flatten function
-func readExifData(filePath string) (map[string]string, error) {
+// Read a file's exif data as reported by exiftool
+func readExifData(filePath string) (map[string]interface{}, error) {
 	cmd := exec.Command("exiftool", "-j", filePath)
 	stdout, err := cmd.Output()
 	if err != nil {
 		return nil, err
 	}
 	var data []map[string]interface{}
-	if err := json.Unmarshal(stdout, &data); err != nil {
+	if err := json.Unmarshal(stdout, &data); err != nil || len(data) == 0 {
 		// Handle the case when the file has no EXIF data
-		emptyData := make(map[string]string)
-		return emptyData, nil
+		return nil, nil
 	}
-	result := make(map[string]string)
-	for k, v := range data[0] {
-		flatten(result, k, reflect.ValueOf(v))
-	}
-	return result, nil
+	return data[0], nil
 }
 
-// Compute the sha1 hash of a string
+// Compute the hash of a path with the configured path hash algorithm
 func computeStringHash(input string) string {
-	hash := sha1.New()
-	hash.Write([]byte(input))
-	hashBytes := hash.Sum(nil)
-	hashValue := hex.EncodeToString(hashBytes)
-	return hashValue
+	return pathHashAlgo.Sum([]byte(input))
 }
 
 // Identify the ancestry paths
@@ -365,74 +329,12 @@ func ancestryPathHashes(ancestryPaths []string) []string {
 	return hashes
 }
 
-// Compute the sha1 hash of a file
-func computeFileHash(filename string) string {
-	f, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-
-	h := sha1.New()
-	if _, err := io.Copy(h, f); err != nil {
-		log.Fatal(err)
-	}
-
-	return hex.EncodeToString(h.Sum(nil))
+// Compute the hash of a file with the configured algorithm
+func computeFileHash(filename string) (string, error) {
+	return hashAlgo.File(filename)
 }
 
 // Function to check if a file info represents a symbolic link
 func isSymbolicLink(fileInfo os.FileInfo) bool {
 	return fileInfo.Mode()&os.ModeSymlink != 0
 }
-
-// Flatten nested map data
-func flatten(result map[string]string, prefix string, v reflect.Value) {
-	if v.Kind() == reflect.Interface {
-		v = v.Elem()
-	}
-
-	switch v.Kind() {
-	case reflect.Bool:
-		if v.Bool() {
-			result[prefix] = "true"
-		} else {
-			result[prefix] = "false"
-		}
-	case reflect.Int:
-		result[prefix] = fmt.Sprintf("%d", v.Int())
-	case reflect.Float64:
-		result[prefix] = fmt.Sprintf("%f", v.Float())
-	case reflect.Map:
-		flattenMap(result, prefix, v)
-	case reflect.Slice:
-		flattenSlice(result, prefix, v)
-	case reflect.String:
-		result[prefix] = v.String()
-	default:
-		panic(fmt.Sprintf("Couldn't deal with: %s", v))
-	}
-}
-
-// Flatten nested map data with map keys
-func flattenMap(result map[string]string, prefix string, v reflect.Value) {
-	for _, k := range v.MapKeys() {
-		if k.Kind() == reflect.Interface {
-			k = k.Elem()
-		}
-
-		if k.Kind() != reflect.String {
-			panic(fmt.Sprintf("%s: map key is not string: %s", prefix, k))
-		}
-
-		flatten(result, fmt.Sprintf("%s.%s", prefix, k.String()), v.MapIndex(k))
-	}
-}
-
-// Flatten nested map data with slice values
-func flattenSlice(result map[string]string, prefix string, v reflect.Value) {
-	prefix = prefix + "."
-	for i := 0; i < v.Len(); i++ {
-		flatten(result, fmt.Sprintf("%s%d", prefix, i), v.Index(i))
-	}
-}
@@ -2,51 +2,50 @@ package main
 
 import (
 	"context"
-	"crypto/sha1"
-	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"reflect"
-	"strconv"
-	"strings"
-	"sync"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"RSKGroup/OPIe/utils/indexer"
+	"RSKGroup/OPIe/utils/pathFilter"
 )
 
+// Config is conf.json. The indexing settings are shared with the watcher
+// through indexer.Options, and the include/exclude rules through
+// pathFilter.Config.
 type Config struct {
-	DbType        string   `json:"DbType"`
-	Host          string   `json:"Host"`
-	Port          string   `json:"Port"`
-	DbUser        string   `json:"DbUser"`
-	DbPwd         string   `json:"DbPwd"`
-	DbName        string   `json:"DbName"`
-	FileColl      string   `json:"FileColl"`
-	TreeColl      string   `json:"TreeColl"`
-	MaxGoroutines int      `json:"maxGoroutines"`
-	NoExif        []string `json:"NoExif"`
-	Watcher       []string `json:"Watcher"`
-	Root          string   `json:"root"`
-	Path          string   `json:"path"`
+	MaxGoroutines  int      `json:"maxGoroutines"`
+	Watcher        []string `json:"Watcher"`
+	Root           string   `json:"root"`
+	Path           string   `json:"path"`
+	CheckpointFile string   `json:"CheckpointFile"`
+	DirReaders     int      `json:"DirReaders"`
+	Hashers        int      `json:"Hashers"`
+	Writers        int      `json:"Writers"`
+	QueueSize      int      `json:"QueueSize"`
+	FollowSymlinks bool     `json:"FollowSymlinks"`
+	indexer.Options
+	// Include, Exclude, MaxDepth, MinSize, MaxSize, SkipHidden and IgnoreFile
+	pathFilter.Config
 }
 
-var config *string
 var path *string
 var root *string
-var watcher *bool
-var fileCollection *mongo.Collection
-var workerCount = 0
-var workerPool = make(chan struct{}, workerCount)
+var incremental *bool
+var sweep *bool
+var purge *bool
+var migrate *bool
+var rehash *bool
+var deepHash *bool
+var resume *bool
+var followSymlinks *bool
+var timeout *time.Duration
+var scanCheckpoint *checkpoint
+var scanFilter *pathFilter.Filter
 
 func init() {
 	// Read the configuration file
@@ -58,17 +57,24 @@ func init() {
 
 	path = flag.String("path", config.Path, "full path")
 	root = flag.String("root", config.Root, "root path")
-	watcher = flag.Bool("watcher", false, "watcher")
-
-	// Update the workerCount value
-	workerCount = config.MaxGoroutines
-	workerPool = make(chan struct{}, workerCount)
+	// Ignored; kept so scripts that still pass it keep working
+	flag.Bool("watcher", false, "ignored")
+	incremental = flag.Bool("incremental", false, "skip hashing and exif for files whose size and mtime are unchanged")
+	sweep = flag.Bool("sweep", true, "mark documents under path that were not seen by this run as deleted")
+	purge = flag.Bool("purge", false, "delete stale documents instead of marking them")
+	migrate = flag.Bool("migrate", false, "rewrite existing document ids and path hashes to the configured IdStrategy and PathHashAlgo and exit")
+	rehash = flag.Bool("rehash", false, "re-hash indexed files not hashed with the configured HashAlgo and exit")
+	deepHash = flag.Bool("deephash", false, "fill in full hashes of files that only have a quick fingerprint and exit")
+	resume = flag.Bool("resume", false, "continue an interrupted scan from its checkpoint file")
+	followSymlinks = flag.Bool("follow", config.FollowSymlinks, "index what symbolic links lead to, unless it is inside root")
+	timeout = flag.Duration("timeout", 0, "stop the scan after this long, as if interrupted (0 for no limit)")
 }
 
 func main() {
 	flag.Parse()
 
 	startTime := time.Now()
+	runID := indexer.NewRunID(startTime)
 	config, err := readConfig("conf.json")
 	if err != nil {
 		log.Fatalf("Failed to read configuration file: %v", err)
@@ -81,275 +87,198 @@ func main() {
 		rootValue = pathValue
 	}
 
-	if config.MaxGoroutines > 0 {
-		workerCount = config.MaxGoroutines
-		workerPool = make(chan struct{}, workerCount)
-		fmt.Println(workerCount, "work")
+	// The first SIGINT/SIGTERM (or the timeout) stops the scan; writes already
+	// under way keep their own context so they can still land
+	scanCtx, cancelScan := context.WithCancel(context.Background())
+	defer cancelScan()
+	if *timeout > 0 {
+		scanCtx, cancelScan = context.WithTimeout(scanCtx, *timeout)
+		defer cancelScan()
 	}
-
-	collection, err := connectToMongoDB(config.DbType, config.Host, config.Port, config.DbUser, config.DbPwd, config.DbName, config.FileColl)
+	writeCtx, cancelWrites := context.WithCancel(context.Background())
+	defer cancelWrites()
+	stopSignals := handleSignals(cancelScan, cancelWrites)
+	defer stopSignals()
+
+	stages := newStageConfig(config)
+	config.ExifWorkers = stages.ExifWorkers
+	ix, err := indexer.New(config.Options)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
-	}
-
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
-	go processPath(collection, *path, *root, *watcher, wg)
-	wg.Wait() // Wait for all goroutines to finish.
-	elapsedTime := time.Since(startTime)
-	log.Printf("Execution time: %s", elapsedTime)
-}
-
-func readConfig(filename string) (Config, error) {
-	var config Config
-
-	// Read the JSON file
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return config, fmt.Errorf("failed to read configuration file: %v", err)
+		log.Fatal(err)
 	}
-
-	// Unmarshal the JSON data into the Config struct
-	err = json.Unmarshal(data, &config)
-	if err != nil {
-		return config, fmt.Errorf("failed to unmarshal configuration data: %v", err)
+	defer ix.Close()
+	ix.Incremental = *incremental
+	sink := ix.Sink()
+	if _, ok := sink.(indexer.IndexLookup); !ok && *incremental {
+		log.Printf("Sink %q can't look documents up; -incremental indexes every file", config.Sink)
 	}
 
-	return config, nil
-}
-
-// Process the path
-func processPath(collection *mongo.Collection, pathValue, rootValue string, watcherValue bool, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	// Get file information once
-	fileInfo, err := readFileInfo(pathValue)
-	if err != nil {
-		log.Printf("Failed to read file info: %v\n", err)
+	if *migrate {
+		migrator, ok := sink.(indexer.IndexMigrator)
+		if !ok {
+			log.Fatalf("Sink %q does not support id migration", config.Sink)
+		}
+		count, err := migrator.Migrate(scanCtx, ix.IDStrategy())
+		if err != nil {
+			log.Fatalf("Failed to migrate document ids: %v", err)
+		}
+		log.Printf("Migrated %d documents to the %s id strategy and %s path hashes", count, ix.IDStrategy(), ix.PathHashAlgo())
 		return
 	}
 
-	// Create a channel for completion signals
-	complete := make(chan bool)
-
-	// Submit task to the worker pool
-	workerPool <- struct{}{}
-	go func() {
-		err := runCompileAndWrite(collection, pathValue, rootValue, watcherValue, fileInfo)
-		<-workerPool // Release the worker slot when completed
-		if err != nil {
-			log.Printf("Error processing path: %v\n", err)
+	if *rehash {
+		rehasher, ok := sink.(indexer.IndexRehasher)
+		if !ok {
+			log.Fatalf("Sink %q does not support re-hashing", config.Sink)
 		}
-		complete <- true
-	}()
-
-	if fileInfo.IsDir() && !isSymbolicLink(fileInfo) {
-		// If it's a directory and not a symbolic link, process its contents
-		// Open the directory
-		dir, err := os.Open(pathValue)
+		count, err := rehasher.Rehash(scanCtx, ix, stages.Hashers)
 		if err != nil {
-			log.Printf("Failed to open directory: %v\n", err)
-			return
+			log.Fatalf("Failed to re-hash documents: %v", err)
 		}
-		defer dir.Close()
+		log.Printf("Re-hashed %d documents with %s", count, ix.HashAlgo())
+		return
+	}
 
-		// Read all the directory entries
-		entries, err := dir.Readdir(-1)
+	if *deepHash {
+		rehasher, ok := sink.(indexer.IndexRehasher)
+		if !ok {
+			log.Fatalf("Sink %q does not support deep hashing", config.Sink)
+		}
+		count, err := rehasher.DeepHash(scanCtx, ix, stages.Hashers)
 		if err != nil {
-			log.Printf("Failed to read directory entries: %v\n", err)
-			return
+			log.Fatalf("Failed to deep hash documents: %v", err)
 		}
+		log.Printf("Deep hashed %d documents with %s", count, ix.HashAlgo())
+		return
+	}
 
-		// Loop over the directory entries and process each one
-		for _, entry := range entries {
-			entryPath := filepath.Join(pathValue, entry.Name())
-			wg.Add(1)
-			go processPath(collection, entryPath, rootValue, watcherValue, wg)
+	// Journal progress so an interrupted scan can be resumed
+	if config.CheckpointFile != "" {
+		flushInterval := time.Duration(config.FlushSeconds) * time.Second
+		if *resume {
+			var header checkpointEntry
+			scanCheckpoint, header, err = resumeCheckpoint(config.CheckpointFile, sink, flushInterval)
+			if err != nil {
+				log.Fatalf("Failed to resume scan: %v", err)
+			}
+			runID, pathValue, rootValue = header.RunID, header.Path, header.Root
+			log.Printf("Resuming run %s of %s: %d directories to finish", runID, pathValue, scanCheckpoint.Pending())
+		} else {
+			scanCheckpoint, err = newCheckpoint(config.CheckpointFile, runID, pathValue, rootValue, sink, flushInterval)
+			if err != nil {
+				log.Fatalf("Failed to start checkpoint: %v", err)
+			}
 		}
+	} else if *resume {
+		log.Fatalf("Resuming requires CheckpointFile in conf.json")
 	}
 
-	// Wait for the completion signal
-	<-complete
-}
+	// Stamp documents with the run, and list files that fail to index in a
+	// per-run report
+	ix.StartRun(runID)
 
-// Determine file type and do both compileData and saveDataToDB
-func runCompileAndWrite(collection *mongo.Collection, pathValue, rootValue string, watcherValue bool, fileInfo os.FileInfo) error {
-	dataInfo, err := compileData(pathValue, rootValue, fileInfo)
+	// Skip what the include/exclude rules and ignore files leave out
+	scanFilter, err = pathFilter.New(rootValue, config.Config)
 	if err != nil {
-		return err
+		log.Fatalf("Invalid configuration: %v", err)
 	}
-
-	err = saveDataToDB(collection, dataInfo)
-	if err != nil {
-		return fmt.Errorf("failed to save data to MongoDB: %v", err)
+	scanFilter.OnError = func(path string, err error) {
+		ix.ReportError(path, indexer.StageStat, err)
 	}
 
-	return nil
-}
+	summary := runScan(scanCtx, writeCtx, ix, pathValue, rootValue, stages)
 
-// Compile directory or file data
-func compileData(pathValue, rootValue string, fileInfo os.FileInfo) (map[string]string, error) {
-	if isSymbolicLink(fileInfo) {
-		// For symlinks, handle symlink data
-		linkPath, err := os.Readlink(pathValue)
+	// Make sure every queued write has landed, or failed, before they are
+	// counted and the sweep relies on them
+	var flushErr error
+	if scanCheckpoint != nil {
+		flushErr = scanCheckpoint.Flush()
+	} else if flusher, ok := sink.(IndexFlusher); ok {
+		flushErr = flusher.Flush()
+	}
+	if flushErr != nil {
+		log.Printf("Failed to flush pending writes: %v\n", flushErr)
+	}
+	failedFiles, failedDirectories := ix.WriteFailures()
+	summary.Files -= failedFiles
+	summary.Directories -= failedDirectories
+	summary.Errors += failedFiles + failedDirectories
+	summary.WriteFailures += failedFiles + failedDirectories
+	writesFailed := summary.WriteFailures > 0
+
+	// Tombstone anything under the scanned path that this run didn't see. An
+	// interrupted run didn't see everything, and a document that failed to
+	// write would look stale, so neither must sweep.
+	if writesFailed && *sweep {
+		log.Printf("Not sweeping: %d documents failed to write", summary.WriteFailures)
+	}
+	sweeper, canSweep := sink.(indexer.IndexSweeper)
+	if !canSweep && *sweep {
+		log.Printf("Not sweeping: sink %q can't sweep stale documents", config.Sink)
+	}
+	if canSweep && *sweep && !summary.Interrupted && !writesFailed {
+		count, err := sweeper.Sweep(writeCtx, pathValue, runID, *purge)
 		if err != nil {
-			return nil, err
-		}
-		symlinkIsDir := ""
-		if fileInfo.IsDir() {
-			symlinkIsDir = "true"
+			log.Printf("Failed to sweep stale documents: %v\n", err)
 		} else {
-			symlinkIsDir = "false"
+			log.Printf("Run %s tombstoned %d stale documents", runID, count)
 		}
+	}
 
-		symlinkInfo := map[string]string{
-			"_id":                computeStringHash(pathValue) + ":" + computeStringHash(time.Now().Format("2006-01-02 15:04:05")),
-			"SourceFile":         pathValue,
-			"DirectoryName":      filepath.Dir(pathValue),
-			"FileName":           fileInfo.Name(),
-			"FileSizeRaw":        strconv.FormatInt(fileInfo.Size(), 10),
-			"FileMode":           fileInfo.Mode().String(),
-			"FileModTime":        fileInfo.ModTime().Format("2006-01-02 15:04:05"),
-			"SourcePathHash":     computeStringHash(pathValue),
-			"DirectoryHash":      computeStringHash(filepath.Dir(pathValue)),
-			"AncestryPaths":      strings.Join(ancestryPaths(pathValue, rootValue), ", "),
-			"AncestryPathHashes": strings.Join(ancestryPathHashes(ancestryPaths(pathValue, rootValue)), ", "),
-			"IndexTime":          time.Now().Format("2006-01-02 15:04:05"),
-			"IsDirectory":        symlinkIsDir,
-			"IsSymLink":          "true",
-			"SymlinkDestination": linkPath,
-		}
-		return symlinkInfo, nil
-	} else if fileInfo.IsDir() {
-		// For directories, compile directory data
-		dirInfo := map[string]string{
-			"_id":                computeStringHash(pathValue) + ":" + computeStringHash(time.Now().Format("2006-01-02 15:04:05")),
-			"SourceFile":         pathValue,
-			"DirectoryName":      filepath.Dir(pathValue),
-			"FileName":           fileInfo.Name(),
-			"FileSizeRaw":        strconv.FormatInt(fileInfo.Size(), 10),
-			"FileMode":           fileInfo.Mode().String(),
-			"FileModTime":        fileInfo.ModTime().Format("2006-01-02 15:04:05"),
-			"SourcePathHash":     computeStringHash(pathValue),
-			"DirectoryHash":      computeStringHash(filepath.Dir(pathValue)),
-			"AncestryPaths":      strings.Join(ancestryPaths(pathValue, rootValue), ", "),
-			"AncestryPathHashes": strings.Join(ancestryPathHashes(ancestryPaths(pathValue, rootValue)), ", "),
-			"IndexTime":          time.Now().Format("2006-01-02 15:04:05"),
-			"IsDirectory":        "true",
+	// A clean finish leaves nothing to resume; an interrupted one, or one
+	// that failed to write, keeps the checkpoint so -resume can pick up where
+	// it stopped
+	if scanCheckpoint != nil {
+		if err := scanCheckpoint.Close(!summary.Interrupted && !writesFailed); err != nil {
+			log.Printf("Failed to close checkpoint: %v\n", err)
 		}
-		childDirs, childFiles, childSize, descendantDirs, descendantFiles, descendantSize := calculateCountsAndSizes(pathValue)
-
-		dirInfo["ChildDirectoryCount"] = strconv.Itoa(childDirs)
-		dirInfo["ChildFileCount"] = strconv.Itoa(childFiles)
-		dirInfo["ChildSizeRaw"] = strconv.FormatInt(childSize, 10)
-		dirInfo["DescendentDirectoryCount"] = strconv.Itoa(descendantDirs)
-		dirInfo["DescendentFileCount"] = strconv.Itoa(descendantFiles)
-		dirInfo["DescendentSizeRaw"] = strconv.FormatInt(descendantSize, 10)
+	}
 
-		return dirInfo, nil
-	} else {
-		// For files, compile file data
-		exifData, err := readExifData(pathValue)
-		if err != nil {
-			// If exif data is not available, compile data without exif
-			fileHash := computeFileHash(pathValue)
-			fileInfo := map[string]string{
-				"_id":                computeStringHash(pathValue) + ":" + fileHash,
-				"SourceFile":         pathValue,
-				"DirectoryName":      filepath.Dir(pathValue),
-				"FileName":           fileInfo.Name(),
-				"FileSizeRaw":        strconv.FormatInt(fileInfo.Size(), 10),
-				"FileMode":           fileInfo.Mode().String(),
-				"FileModTime":        fileInfo.ModTime().Format("2006-01-02 15:04:05"),
-				"IndexTime":          time.Now().Format("2006-01-02 15:04:05"),
-				"IsDirectory":        "false",
-				"SourcePathHash":     computeStringHash(pathValue),
-				"DirectoryHash":      computeStringHash(filepath.Dir(pathValue)),
-				"AncestryPaths":      strings.Join(ancestryPaths(pathValue, rootValue), ", "),
-				"AncestryPathHashes": strings.Join(ancestryPathHashes(ancestryPaths(pathValue, rootValue)), ", "),
-				"FileHash":           fileHash,
-			}
-			return fileInfo, nil
+	summary.log(runID, time.Since(startTime))
+	if counter, ok := sink.(indexer.IndexCounter); ok {
+		documents, versions, treeNodes := counter.Counts()
+		log.Printf("%d documents, %d file versions and %d tree nodes in the index\n", documents, versions, treeNodes)
+	}
+	if report, count := ix.ErrorReport(); count > 0 {
+		log.Printf("Errors were written to %s", report)
+	}
+	if summary.Interrupted {
+		// Exiting skips the deferred closes, so flush pending writes first
+		if err := ix.Close(); err != nil {
+			log.Printf("Failed to flush pending writes: %v\n", err)
 		}
-
-		// Add additional file information to exif data
-		fileHash := computeFileHash(pathValue)
-		exifData["_id"] = computeStringHash(pathValue) + ":" + fileHash
-		exifData["SourcePathHash"] = computeStringHash(pathValue)
-		exifData["DirectoryHash"] = computeStringHash(filepath.Dir(pathValue))
-		exifData["FileHash"] = computeFileHash(pathValue)
-		exifData["FileSizeRaw"] = strconv.FormatInt(fileInfo.Size(), 10)
-		exifData["FileMode"] = fileInfo.Mode().String()
-		exifData["FileModTime"] = fileInfo.ModTime().Format("2006-01-02 15:04:05")
-		exifData["AncestryPaths"] = strings.Join(ancestryPaths(pathValue, rootValue), ", ")
-		exifData["AncestryPathHashes"] = strings.Join(ancestryPathHashes(ancestryPaths(pathValue, rootValue)), ", ")
-		exifData["FileTypeExtension"] = filepath.Ext(fileInfo.Name())
-		exifData["IsDirectory"] = "false"
-		exifData["IndexTime"] = time.Now().Format("2006-01-02 15:04:05")
-
-		return exifData, nil
+		os.Exit(1)
 	}
 }
 
-// DATABASE FUNCTIONS
-// Connect to MongoDB and return the collection
-func connectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName, collectionName string) (*mongo.Collection, error) {
-	// Construct MongoDB connection URI
-	mongodbURI := dbType + "://" + dbUser + ":" + dbPwd + "@" + host + ":" + port
-
-	// Configure the client connection
-	clientOptions := options.Client().ApplyURI(mongodbURI)
+func readConfig(filename string) (Config, error) {
+	var config Config
 
-	// Connect to MongoDB
-	client, err := mongo.Connect(context.Background(), clientOptions)
+	// Read the JSON file
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
+		return config, fmt.Errorf("failed to read configuration file: %v", err)
 	}
 
-	// Check if the connection was successful
-	err = client.Ping(context.Background(), nil)
+	// Unmarshal the JSON data into the Config struct
+	err = json.Unmarshal(data, &config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to ping MongoDB: %v", err)
+		return config, fmt.Errorf("failed to unmarshal configuration data: %v", err)
 	}
 
-	// Access the specified database and collection
-	db := client.Database(dbName)
-	collection := db.Collection(collectionName)
-
-	return collection, nil
+	return config, nil
 }
 
-// Save data to MongoDB
-func saveDataToDB(collection *mongo.Collection, data map[string]string) error {
-	// fmt.Println("Saving the following data to the database:")
-	doc := bson.M{}
-	for key, value := range data {
-		// fmt.Printf("%s: %s\n", key, value)
-		doc[key] = value
-	}
-	// fmt.Println("DOC======\n", doc)
-	// fmt.Println("\nDatatattata\n", data)
-
-	// Set the filter to check if the document with the given _id already exists
-	filter := bson.M{"_id": doc["_id"]}
-
-	// Set the update to replace the existing document with the new data
-	update := bson.M{"$set": doc}
-
-	// Set the options for upsert (create if not exists)
-	options := options.Update().SetUpsert(true)
-
-	// Perform the upsert operation in MongoDB
-	_, err := collection.UpdateOne(context.Background(), filter, update, options)
+// Read all the entries of a directory
+func readDirEntries(pathValue string) ([]os.FileInfo, error) {
+	dir, err := os.Open(pathValue)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	return nil
+	defer dir.Close()
+	return dir.Readdir(-1)
 }
 
-// UTILITY FUNCTIONS
-
 // Read a file's info using lstat
 func readFileInfo(filePath string) (os.FileInfo, error) {
 	fileInfo, err := os.Lstat(filePath)
@@ -359,179 +288,7 @@ func readFileInfo(filePath string) (os.FileInfo, error) {
 	return fileInfo, nil
 }
 
-// Read a file's exif data and then flatten it using the flatten function
-func readExifData(filePath string) (map[string]string, error) {
-	cmd := exec.Command("exiftool", "-j", filePath)
-	stdout, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
-	var data []map[string]interface{}
-	if err := json.Unmarshal(stdout, &data); err != nil {
-		// Handle the case when the file has no EXIF data
-		emptyData := make(map[string]string)
-		return emptyData, nil
-	}
-	result := make(map[string]string)
-	for k, v := range data[0] {
-		flatten(result, k, reflect.ValueOf(v))
-	}
-	return result, nil
-}
-
-// Compute the sha1 hash of a string
-func computeStringHash(input string) string {
-	hash := sha1.New()
-	hash.Write([]byte(input))
-	hashBytes := hash.Sum(nil)
-	hashValue := hex.EncodeToString(hashBytes)
-	return hashValue
-}
-
-// Identify the ancestry paths
-func ancestryPaths(pathValue, rootValue string) []string {
-	file := pathValue
-	root := rootValue
-
-	// Get the ancestry paths
-	var paths []string
-	for {
-		file = filepath.Dir(file)
-		paths = append(paths, file)
-		if file == root {
-			break
-		}
-	}
-	return paths
-}
-
-// Compute ancestry path hashes
-func ancestryPathHashes(ancestryPaths []string) []string {
-	var hashes []string
-	for _, path := range ancestryPaths {
-		hash := computeStringHash(path)
-		hashes = append(hashes, hash)
-	}
-	return hashes
-}
-
-// Compute the sha1 hash of a file
-func computeFileHash(filename string) string {
-	f, err := os.Open(filename)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer f.Close()
-
-	h := sha1.New()
-	if _, err := io.Copy(h, f); err != nil {
-		log.Fatal(err)
-	}
-
-	return hex.EncodeToString(h.Sum(nil))
-}
-
 // Function to check if a file info represents a symbolic link
 func isSymbolicLink(fileInfo os.FileInfo) bool {
 	return fileInfo.Mode()&os.ModeSymlink != 0
 }
-
-// Flatten nested map data
-func flatten(result map[string]string, prefix string, v reflect.Value) {
-	if v.Kind() == reflect.Interface {
-		v = v.Elem()
-	}
-
-	switch v.Kind() {
-	case reflect.Bool:
-		if v.Bool() {
-			result[prefix] = "true"
-		} else {
-			result[prefix] = "false"
-		}
-	case reflect.Int:
-		result[prefix] = fmt.Sprintf("%d", v.Int())
-	case reflect.Float64:
-		result[prefix] = fmt.Sprintf("%f", v.Float())
-	case reflect.Map:
-		flattenMap(result, prefix, v)
-	case reflect.Slice:
-		flattenSlice(result, prefix, v)
-	case reflect.String:
-		result[prefix] = v.String()
-	default:
-		panic(fmt.Sprintf("Couldn't deal with: %s", v))
-	}
-}
-
-// Flatten nested map data with map keys
-func flattenMap(result map[string]string, prefix string, v reflect.Value) {
-	for _, k := range v.MapKeys() {
-		if k.Kind() == reflect.Interface {
-			k = k.Elem()
-		}
-
-		if k.Kind() != reflect.String {
-			panic(fmt.Sprintf("%s: map key is not string: %s", prefix, k))
-		}
-
-		flatten(result, fmt.Sprintf("%s.%s", prefix, k.String()), v.MapIndex(k))
-	}
-}
-
-// Flatten nested map data with slice values
-func flattenSlice(result map[string]string, prefix string, v reflect.Value) {
-	prefix = prefix + "."
-	for i := 0; i < v.Len(); i++ {
-		flatten(result, fmt.Sprintf("%s%d", prefix, i), v.Index(i))
-	}
-}
-
-// Calculate child and descendant counts and sizes
-func calculateCountsAndSizes(path string) (int, int, int64, int, int, int64) {
-	childDirs := 0
-	childFiles := 0
-	childSize := int64(0)
-	descendantDirs := 0
-	descendantFiles := 0
-	descendantSize := int64(0)
-
-	// Open the directory
-	dir, err := os.Open(path)
-	if err != nil {
-		log.Printf("Failed to open directory: %v\n", err)
-		return childDirs, childFiles, childSize, descendantDirs, descendantFiles, descendantSize
-	}
-	defer dir.Close()
-
-	// Read all the directory entries
-	entries, err := dir.Readdir(-1)
-	if err != nil {
-		log.Printf("Failed to read directory entries: %v\n", err)
-		return childDirs, childFiles, childSize, descendantDirs, descendantFiles, descendantSize
-	}
-
-	for _, entry := range entries {
-		entryPath := filepath.Join(path, entry.Name())
-
-		if entry.IsDir() {
-			childDirs++
-			descendantDirs++
-			// Recursively calculate counts and sizes for subdirectories
-			subChildDirs, subChildFiles, subChildSize, subDescendantDirs, subDescendantFiles, subDescendantSize := calculateCountsAndSizes(entryPath)
-			childDirs += subChildDirs
-			childFiles += subChildFiles
-			childSize += subChildSize
-			descendantDirs += subDescendantDirs
-			descendantFiles += subDescendantFiles
-			descendantSize += subDescendantSize
-		} else {
-			childFiles++
-			childSize += entry.Size()
-			descendantFiles++
-			descendantSize += entry.Size()
-		}
-	}
-
-	return childDirs, childFiles, childSize, descendantDirs, descendantFiles, descendantSize
-}
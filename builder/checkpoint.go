@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"RSKGroup/OPIe/utils/indexer"
+)
+
+// IndexFlusher is implemented by sinks that buffer writes. Flush must make
+// everything written so far durable.
+type IndexFlusher interface {
+	Flush() error
+}
+
+// checkpointEntry is one line of the checkpoint journal. The first line
+// describes the run; every later line records a directory the walk started
+// or completed, with the totals of a completed directory's subtree.
+type checkpointEntry struct {
+	RunID     string                   `json:"RunID,omitempty"`
+	Path      string                   `json:"Path,omitempty"`
+	Root      string                   `json:"Root,omitempty"`
+	Started   string                   `json:"Started,omitempty"`
+	Completed string                   `json:"Completed,omitempty"`
+	Totals    *indexer.DirectoryTotals `json:"Totals,omitempty"`
+}
+
+// checkpoint journals the progress of a scan so that a killed run can be
+// resumed. Entries are held in memory and only appended to the file after
+// the sink has flushed, so a directory is never recorded as completed before
+// its documents are stored.
+type checkpoint struct {
+	filename string
+	sink     indexer.IndexSink
+
+	mu       sync.Mutex
+	file     *os.File
+	encoder  *json.Encoder
+	buffered []checkpointEntry
+	// Set once a document failed to write. Nothing is journaled after that,
+	// as a directory completed later may be above the one that failed.
+	failed bool
+
+	// Directories completed by the interrupted run, loaded on resume
+	completed map[string]indexer.DirectoryTotals
+	pending   map[string]bool
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// Start a new checkpoint journal for this run, replacing any old one
+func newCheckpoint(filename, runID, pathValue, rootValue string, sink indexer.IndexSink, flushInterval time.Duration) (*checkpoint, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint file: %v", err)
+	}
+	c := &checkpoint{
+		filename:  filename,
+		sink:      sink,
+		file:      f,
+		encoder:   json.NewEncoder(f),
+		completed: make(map[string]indexer.DirectoryTotals),
+		pending:   make(map[string]bool),
+	}
+	if err := c.encoder.Encode(checkpointEntry{RunID: runID, Path: pathValue, Root: rootValue}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write checkpoint file: %v", err)
+	}
+	c.start(flushInterval)
+	return c, nil
+}
+
+// Reopen the checkpoint journal of an interrupted run. It returns the run's
+// header so the caller can carry on with the same run ID, path and root.
+func resumeCheckpoint(filename string, sink indexer.IndexSink, flushInterval time.Duration) (*checkpoint, checkpointEntry, error) {
+	var header checkpointEntry
+
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, header, fmt.Errorf("failed to open checkpoint file: %v", err)
+	}
+	c := &checkpoint{
+		filename:  filename,
+		sink:      sink,
+		file:      f,
+		encoder:   json.NewEncoder(f),
+		completed: make(map[string]indexer.DirectoryTotals),
+		pending:   make(map[string]bool),
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for first := true; scanner.Scan(); first = false {
+		var entry checkpointEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn final line from the killed run; everything before it is good
+			break
+		}
+		switch {
+		case first:
+			header = entry
+		case entry.Completed != "" && entry.Totals != nil:
+			c.completed[entry.Completed] = *entry.Totals
+			delete(c.pending, entry.Completed)
+		case entry.Started != "":
+			c.pending[entry.Started] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, header, fmt.Errorf("failed to read checkpoint file: %v", err)
+	}
+	if header.RunID == "" {
+		f.Close()
+		return nil, header, fmt.Errorf("checkpoint file %s has no run header", filename)
+	}
+
+	c.start(flushInterval)
+	return c, header, nil
+}
+
+// Start writing buffered entries on a timer
+func (c *checkpoint) start(flushInterval time.Duration) {
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+	c.stop = make(chan struct{})
+	c.stopped.Add(1)
+	go func() {
+		defer c.stopped.Done()
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Flush(); err != nil {
+					log.Printf("Failed to write checkpoint: %v\n", err)
+				}
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Completed returns the totals of a directory finished by the interrupted
+// run, so the walk can skip its whole subtree
+func (c *checkpoint) Completed(pathValue string) (indexer.DirectoryTotals, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	totals, ok := c.completed[pathValue]
+	return totals, ok
+}
+
+// Pending returns how many directories the interrupted run started but
+// didn't finish
+func (c *checkpoint) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// Record that the walk has started on a directory
+func (c *checkpoint) MarkStarted(pathValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buffered = append(c.buffered, checkpointEntry{Started: pathValue})
+}
+
+// Record that a directory and everything below it has been written
+func (c *checkpoint) MarkCompleted(pathValue string, totals indexer.DirectoryTotals) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buffered = append(c.buffered, checkpointEntry{Completed: pathValue, Totals: &totals})
+}
+
+// Flush the sink, then append the buffered entries to the journal. Once a
+// document failed to write, the entries are dropped from then on, so a
+// resumed run redoes every directory not completed before the failure.
+func (c *checkpoint) Flush() error {
+	c.mu.Lock()
+	entries := c.buffered
+	c.buffered = nil
+	c.mu.Unlock()
+
+	if flusher, ok := c.sink.(IndexFlusher); ok {
+		if err := flusher.Flush(); err != nil {
+			c.mu.Lock()
+			c.failed = true
+			c.mu.Unlock()
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(entries) == 0 || c.failed {
+		return nil
+	}
+	for _, entry := range entries {
+		if err := c.encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return c.file.Sync()
+}
+
+// Stop journaling. After a clean finish the checkpoint file is removed;
+// otherwise it is left in place for -resume.
+func (c *checkpoint) Close(clean bool) error {
+	close(c.stop)
+	c.stopped.Wait()
+
+	err := c.Flush()
+	if closeErr := c.file.Close(); err == nil {
+		err = closeErr
+	}
+	if clean && err == nil {
+		err = os.Remove(c.filename)
+	}
+	return err
+}
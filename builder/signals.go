@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Cancel the scan on the first SIGINT or SIGTERM so in-flight work drains
+// and pending writes are flushed. A second signal cancels the writes too.
+// The returned function stops listening.
+func handleSignals(cancelScan, cancelWrites context.CancelFunc) func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v: finishing in-flight work, signal again to abort pending writes", sig)
+			cancelScan()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			log.Printf("Received %v: aborting pending writes", sig)
+			cancelWrites()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"RSKGroup/OPIe/utils/indexer"
+	"RSKGroup/OPIe/utils/symlink"
+)
+
+// Concurrency of each stage of the scan pipeline and the capacity of the
+// queues between them. Full queues block the stage feeding them, so a slow
+// sink slows the whole scan down instead of piling up work in memory.
+type stageConfig struct {
+	DirReaders  int
+	Hashers     int
+	ExifWorkers int
+	Writers     int
+	QueueSize   int
+}
+
+// Read the stage sizes from conf.json, filling in defaults for unset ones
+func newStageConfig(config Config) stageConfig {
+	stages := stageConfig{
+		DirReaders:  config.DirReaders,
+		Hashers:     config.Hashers,
+		ExifWorkers: config.ExifWorkers,
+		Writers:     config.Writers,
+		QueueSize:   config.QueueSize,
+	}
+	if stages.DirReaders <= 0 {
+		stages.DirReaders = 4
+	}
+	if stages.Hashers <= 0 {
+		stages.Hashers = runtime.NumCPU()
+	}
+	if stages.ExifWorkers <= 0 {
+		// exiftool used to be bounded by maxGoroutines, so keep honoring it
+		stages.ExifWorkers = config.MaxGoroutines
+	}
+	if stages.ExifWorkers <= 0 {
+		stages.ExifWorkers = runtime.NumCPU()
+	}
+	if stages.Writers <= 0 {
+		stages.Writers = 4
+	}
+	if stages.QueueSize <= 0 {
+		stages.QueueSize = 1000
+	}
+	return stages
+}
+
+// dirNode tracks a directory until everything below it has been written.
+// remaining counts children not yet written, plus one while the directory
+// itself is still being read; when it drops to zero the directory's own
+// document is written with the totals its children folded into it.
+// A directory is incomplete when the scan was cancelled before all of its
+// subtree was written; it is then left for a resumed run to finish. link is
+// set for a directory reached through a followed symbolic link.
+type dirNode struct {
+	path   string
+	info   os.FileInfo
+	link   *symlink.Link
+	parent *dirNode
+
+	mu         sync.Mutex
+	totals     indexer.DirectoryTotals
+	remaining  int
+	incomplete bool
+	// A write failed in its subtree, so it isn't checkpointed
+	writeFailed bool
+}
+
+// scanItem is a non-directory entry moving through the hash, exif and
+// write stages
+type scanItem struct {
+	path    string
+	info    os.FileInfo
+	parent  *dirNode
+	content indexer.FileContent
+}
+
+// scanSummary counts what a scan did, for the report at the end of a run
+type scanSummary struct {
+	Directories int64
+	Files       int64
+	Unchanged   int64
+	Errors      int64
+	Abandoned   int64
+	// Documents that failed to write, also counted in Errors
+	WriteFailures int64
+	Interrupted   bool
+}
+
+// Report the summary of a run
+func (s *scanSummary) log(runID string, elapsed time.Duration) {
+	status := "completed"
+	if s.Interrupted {
+		status = "interrupted"
+	}
+	log.Printf("Run %s %s: %d directories, %d files written, %d unchanged, %d errors, %d abandoned",
+		runID, status, s.Directories, s.Files, s.Unchanged, s.Errors, s.Abandoned)
+	log.Printf("Execution time: %s", elapsed)
+}
+
+// scanner runs the pipeline: directory readers feed entries to hashers,
+// hashers to exif extractors, and exif extractors to writers. Cancelling ctx
+// stops the walk: nothing new is read, entries not yet hashed are abandoned,
+// and entries already waiting to be written are still written with writeCtx.
+type scanner struct {
+	ctx       context.Context
+	writeCtx  context.Context
+	ix        *indexer.Indexer
+	rootValue string
+	stages    stageConfig
+
+	// Directories waiting to be read. Readers add to it themselves, so it
+	// can't be a bounded channel without risking a deadlock; it is a stack
+	// so subtrees finish (and checkpoint) as early as possible.
+	dirMu   sync.Mutex
+	dirCond *sync.Cond
+	dirs    []*dirNode
+	closed  bool
+
+	hashQueue  chan *scanItem
+	exifQueue  chan *scanItem
+	writeQueue chan *scanItem
+
+	// Directories and entries not yet written
+	outstanding sync.WaitGroup
+
+	// With -follow, the root with its links resolved, and the files and
+	// directories already reached through a link
+	realRoot string
+	followMu sync.Mutex
+	followed map[symlink.FileID]string
+
+	summary scanSummary
+}
+
+// Scan the path with the pipeline and return once everything is written, or
+// once in-flight work has drained after ctx is cancelled
+func runScan(ctx, writeCtx context.Context, ix *indexer.Indexer, pathValue, rootValue string, stages stageConfig) scanSummary {
+	s := &scanner{
+		ctx:        ctx,
+		writeCtx:   writeCtx,
+		ix:         ix,
+		rootValue:  rootValue,
+		stages:     stages,
+		hashQueue:  make(chan *scanItem, stages.QueueSize),
+		exifQueue:  make(chan *scanItem, stages.QueueSize),
+		writeQueue: make(chan *scanItem, stages.QueueSize),
+		followed:   make(map[symlink.FileID]string),
+	}
+	s.dirCond = sync.NewCond(&s.dirMu)
+	s.realRoot = rootValue
+	if realRoot, err := filepath.EvalSymlinks(rootValue); err == nil {
+		s.realRoot = realRoot
+	}
+
+	// Wake idle readers on cancellation so they can abandon queued directories
+	stopWaking := make(chan struct{})
+	defer close(stopWaking)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.dirMu.Lock()
+			s.dirCond.Broadcast()
+			s.dirMu.Unlock()
+		case <-stopWaking:
+		}
+	}()
+
+	readers := s.startStage(stages.DirReaders, s.readDirs)
+	hashers := s.startStage(stages.Hashers, s.hashEntries)
+	extractors := s.startStage(stages.ExifWorkers, s.extractExif)
+	writers := s.startStage(stages.Writers, s.writeEntries)
+
+	fileInfo, err := readFileInfo(pathValue)
+	if err != nil {
+		s.reportError(pathValue, indexer.StageStat, err)
+	} else if fileInfo.IsDir() && !isSymbolicLink(fileInfo) {
+		s.pushDir(&dirNode{path: pathValue, info: fileInfo})
+	} else {
+		s.outstanding.Add(1)
+		s.hashQueue <- &scanItem{path: pathValue, info: fileInfo}
+	}
+
+	// Once everything is written, let each stage drain and stop in order
+	s.outstanding.Wait()
+	s.dirMu.Lock()
+	s.closed = true
+	s.dirCond.Broadcast()
+	s.dirMu.Unlock()
+	readers.Wait()
+	close(s.hashQueue)
+	hashers.Wait()
+	close(s.exifQueue)
+	extractors.Wait()
+	close(s.writeQueue)
+	writers.Wait()
+
+	s.summary.Interrupted = ctx.Err() != nil
+	return s.summary
+}
+
+// Start n workers running fn
+func (s *scanner) startStage(n int, fn func()) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	return wg
+}
+
+// Queue a directory to be read
+func (s *scanner) pushDir(node *dirNode) {
+	node.remaining = 1
+	s.outstanding.Add(1)
+
+	s.dirMu.Lock()
+	s.dirs = append(s.dirs, node)
+	s.dirMu.Unlock()
+	s.dirCond.Signal()
+}
+
+// Take the next directory to read, or nil once the scan is over
+func (s *scanner) popDir() *dirNode {
+	s.dirMu.Lock()
+	defer s.dirMu.Unlock()
+	for len(s.dirs) == 0 && !s.closed {
+		s.dirCond.Wait()
+	}
+	if len(s.dirs) == 0 {
+		return nil
+	}
+	node := s.dirs[len(s.dirs)-1]
+	s.dirs = s.dirs[:len(s.dirs)-1]
+	return node
+}
+
+// DIRECTORY READERS
+
+func (s *scanner) readDirs() {
+	for node := s.popDir(); node != nil; node = s.popDir() {
+		if s.ctx.Err() != nil {
+			// Cancelled before the directory was read
+			node.mu.Lock()
+			node.incomplete = true
+			node.mu.Unlock()
+			s.childDone(node)
+			continue
+		}
+		s.readDir(node)
+	}
+}
+
+// Read one directory, queueing its subdirectories and sending everything
+// else on to the hashers
+func (s *scanner) readDir(node *dirNode) {
+	if scanCheckpoint != nil {
+		scanCheckpoint.MarkStarted(node.path)
+	}
+
+	entries, err := readDirEntries(node.path)
+	if err != nil {
+		s.reportError(node.path, indexer.StageStat, err)
+	}
+
+	for _, entry := range entries {
+		if s.ctx.Err() != nil {
+			node.mu.Lock()
+			node.incomplete = true
+			node.mu.Unlock()
+			break
+		}
+		entryPath := filepath.Join(node.path, entry.Name())
+		if !scanFilter.Allow(entryPath, entry) {
+			continue
+		}
+
+		var link *symlink.Link
+		if isSymbolicLink(entry) && *followSymlinks {
+			entry, link = s.follow(node, entryPath, entry)
+		}
+
+		if entry.IsDir() && !isSymbolicLink(entry) {
+			if scanCheckpoint != nil {
+				// An interrupted run already wrote this whole subtree
+				if done, ok := scanCheckpoint.Completed(entryPath); ok {
+					node.mu.Lock()
+					node.totals.Add(entry, done)
+					node.mu.Unlock()
+					continue
+				}
+			}
+			node.mu.Lock()
+			node.remaining++
+			node.mu.Unlock()
+			s.pushDir(&dirNode{path: entryPath, info: entry, link: link, parent: node})
+			continue
+		}
+
+		node.mu.Lock()
+		node.totals.Add(entry, indexer.DirectoryTotals{})
+		node.remaining++
+		node.mu.Unlock()
+		s.outstanding.Add(1)
+		s.hashQueue <- &scanItem{path: entryPath, info: entry, parent: node, content: indexer.FileContent{Link: link}}
+	}
+
+	// Reading is done; the directory completes when its last child does
+	s.childDone(node)
+}
+
+// Resolve a symbolic link found in a directory. It returns what the link
+// leads to when it is to be followed, or the link itself when it is to be
+// recorded as a link: when it is broken or loops, when its target is inside
+// the root and so indexed under its own path, or when its target was already
+// reached through another link.
+func (s *scanner) follow(node *dirNode, entryPath string, entry os.FileInfo) (os.FileInfo, *symlink.Link) {
+	link, err := symlink.Resolve(entryPath)
+	if err != nil {
+		s.reportError(entryPath, indexer.StageStat, err)
+		return entry, nil
+	}
+	if link.Broken || link.Loop || symlink.Contains(s.realRoot, link.Target) {
+		return entry, &link
+	}
+	// A link to a directory holding the root would walk back into it
+	if symlink.Contains(link.Target, s.realRoot) {
+		link.Loop = true
+		return entry, &link
+	}
+
+	target, err := os.Stat(entryPath)
+	if err != nil {
+		s.reportError(entryPath, indexer.StageStat, err)
+		return entry, &link
+	}
+	if id, ok := symlink.ID(target); ok {
+		if target.IsDir() {
+			for ancestor := node; ancestor != nil; ancestor = ancestor.parent {
+				if ancestorID, ok := symlink.ID(ancestor.info); ok && ancestorID == id {
+					link.Loop = true
+					return entry, &link
+				}
+			}
+		}
+		s.followMu.Lock()
+		_, seen := s.followed[id]
+		if !seen {
+			s.followed[id] = entryPath
+		}
+		s.followMu.Unlock()
+		if seen {
+			return entry, &link
+		}
+	}
+	return target, &link
+}
+
+// Count one child of a directory as written, completing the directory if it
+// was the last
+func (s *scanner) childDone(node *dirNode) {
+	node.mu.Lock()
+	node.remaining--
+	complete := node.remaining == 0
+	node.mu.Unlock()
+
+	if complete {
+		s.completeDir(node)
+	}
+}
+
+// Write a directory whose whole subtree has been written, then pass its
+// totals up to its parent. An incomplete directory isn't written or
+// checkpointed, since its totals are partial, and makes its parent
+// incomplete too.
+func (s *scanner) completeDir(node *dirNode) {
+	if node.incomplete {
+		atomic.AddInt64(&s.summary.Abandoned, 1)
+	} else {
+		err := s.ix.CompileAndWrite(s.writeCtx, node.path, s.rootValue, node.info, node.totals, indexer.FileContent{Link: node.link})
+		if err != nil {
+			s.writeFailed(node.path, node, err)
+		} else {
+			atomic.AddInt64(&s.summary.Directories, 1)
+		}
+		node.mu.Lock()
+		failed := node.writeFailed
+		node.mu.Unlock()
+		if scanCheckpoint != nil && !failed {
+			scanCheckpoint.MarkCompleted(node.path, node.totals)
+		}
+	}
+
+	if node.parent != nil {
+		node.parent.mu.Lock()
+		if node.incomplete {
+			node.parent.incomplete = true
+		} else {
+			node.parent.totals.Add(node.info, node.totals)
+		}
+		if node.writeFailed {
+			node.parent.writeFailed = true
+		}
+		node.parent.mu.Unlock()
+		s.childDone(node.parent)
+	}
+	s.outstanding.Done()
+}
+
+// Report an error on a path and count it in the summary
+func (s *scanner) reportError(pathValue, stage string, err error) {
+	atomic.AddInt64(&s.summary.Errors, 1)
+	s.ix.ReportError(pathValue, stage, err)
+}
+
+// Report a document that failed to write. The directory it is in, or that
+// it is, and those above it aren't checkpointed, so -resume reads them again.
+func (s *scanner) writeFailed(pathValue string, node *dirNode, err error) {
+	atomic.AddInt64(&s.summary.WriteFailures, 1)
+	s.reportError(pathValue, indexer.StageWrite, err)
+	if node != nil {
+		node.mu.Lock()
+		node.writeFailed = true
+		node.mu.Unlock()
+	}
+}
+
+// Count an entry as finished, whether it was written or skipped
+func (s *scanner) itemDone(item *scanItem) {
+	if item.parent != nil {
+		s.childDone(item.parent)
+	}
+	s.outstanding.Done()
+}
+
+// Drop an entry the cancelled scan won't finish, leaving its directory
+// incomplete
+func (s *scanner) abandonItem(item *scanItem) {
+	atomic.AddInt64(&s.summary.Abandoned, 1)
+	if item.parent != nil {
+		item.parent.mu.Lock()
+		item.parent.incomplete = true
+		item.parent.mu.Unlock()
+	}
+	s.itemDone(item)
+}
+
+// HASHERS
+
+func (s *scanner) hashEntries() {
+	for item := range s.hashQueue {
+		if s.ctx.Err() != nil {
+			s.abandonItem(item)
+			continue
+		}
+		if item.info.Mode().IsRegular() {
+			if s.ix.Incremental {
+				unchanged, err := s.ix.TouchIfUnchanged(s.ctx, item.path, item.info)
+				if err != nil {
+					log.Printf("Failed to look up previous index data: %v\n", err)
+				}
+				if unchanged {
+					atomic.AddInt64(&s.summary.Unchanged, 1)
+					s.itemDone(item)
+					continue
+				}
+			}
+			s.ix.DetectType(&item.content, item.path)
+			if err := s.ix.Hash(&item.content, item.path, item.info.Size()); err != nil {
+				atomic.AddInt64(&s.summary.Errors, 1)
+			}
+		}
+		s.exifQueue <- item
+	}
+}
+
+// EXIF EXTRACTORS
+
+func (s *scanner) extractExif() {
+	for item := range s.exifQueue {
+		if item.info.Mode().IsRegular() {
+			// If exif data is not available the record is written without it
+			var err error
+			item.content.Exif, err = s.ix.ExtractMetadata(s.ctx, item.path, item.content.MIMEType)
+			if err != nil && s.ctx.Err() == nil {
+				s.reportError(item.path, indexer.StageExif, err)
+			}
+		}
+		if s.ctx.Err() != nil {
+			// exiftool may have been killed, so the record would be incomplete
+			s.abandonItem(item)
+			continue
+		}
+		s.writeQueue <- item
+	}
+}
+
+// WRITERS
+
+func (s *scanner) writeEntries() {
+	for item := range s.writeQueue {
+		err := s.ix.CompileAndWrite(s.writeCtx, item.path, s.rootValue, item.info, indexer.DirectoryTotals{}, item.content)
+		if err != nil {
+			s.writeFailed(item.path, item.parent, err)
+		} else {
+			atomic.AddInt64(&s.summary.Files, 1)
+		}
+		s.itemDone(item)
+	}
+}
@@ -3,20 +3,46 @@ module github.com/RSKGroup/OPIe
 go 1.20
 
 require (
+	RSKGroup/OPIe/utils/indexer v0.0.0
+	RSKGroup/OPIe/utils/pathFilter v0.0.0
+	RSKGroup/OPIe/utils/symlink v0.0.0
 	github.com/fsnotify/fsnotify v1.6.0
-	go.mongodb.org/mongo-driver v1.12.0
 )
 
 require (
+	RSKGroup/OPIe/utils/documentId v0.0.0 // indirect
+	RSKGroup/OPIe/utils/fileHash v0.0.0 // indirect
+	RSKGroup/OPIe/utils/fileType v0.0.0 // indirect
+	RSKGroup/OPIe/utils/mongoWrite v0.0.0 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/klauspost/compress v1.13.6 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
 	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
+	github.com/zeebo/blake3 v0.2.3 // indirect
+	go.mongodb.org/mongo-driver v1.12.0 // indirect
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4 // indirect
-	golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
+	golang.org/x/sys v0.4.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 )
+
+replace RSKGroup/OPIe/utils/pathFilter => ./utils/pathFilter
+
+replace RSKGroup/OPIe/utils/indexer => ./utils/indexer
+
+replace RSKGroup/OPIe/utils/documentId => ./utils/documentId
+
+replace RSKGroup/OPIe/utils/mongoWrite => ./utils/mongoWrite
+
+replace RSKGroup/OPIe/utils/fileHash => ./utils/fileHash
+
+replace RSKGroup/OPIe/utils/fileType => ./utils/fileType
+
+replace RSKGroup/OPIe/utils/symlink => ./utils/symlink
+
+replace github.com/fsnotify/fsnotify => ./utils/fsnotify
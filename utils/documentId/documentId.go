@@ -0,0 +1,67 @@
+// Copyright 2023, RSKGroup. All rights reserved.
+// Use of this source code is governed by the GNU/GPLv2 license,
+// which can be found in the LICENSE file.
+
+// Package documentId defines the _id scheme shared by every OPIe builder so
+// that all of them produce compatible collections. Ids are built from the
+// document's SourcePathHash, the hash of its SourceFile with the configured
+// PathHashAlgo. Two strategies exist:
+//
+//	path     _id = SourcePathHash
+//	         One document per path. A changed file overwrites its document.
+//
+//	content  _id = SourcePathHash + ":" + FileHash for regular files,
+//	         SourcePathHash for directories and symlinks.
+//	         One document per distinct content of a file.
+//
+// Neither strategy depends on the time of the run, so re-running a builder
+// over an unchanged tree always yields the same ids.
+package documentId
+
+import "fmt"
+
+// Strategy selects how document ids are derived
+type Strategy string
+
+const (
+	PathIdentity   Strategy = "path"
+	ContentVersion Strategy = "content"
+)
+
+// ParseStrategy reads the IdStrategy configuration value. An empty value
+// selects PathIdentity.
+func ParseStrategy(value string) (Strategy, error) {
+	switch Strategy(value) {
+	case "", PathIdentity:
+		return PathIdentity, nil
+	case ContentVersion:
+		return ContentVersion, nil
+	default:
+		return "", fmt.Errorf("unknown id strategy: %s", value)
+	}
+}
+
+// ForPath returns the id of a directory or symlink document with the given
+// path hash
+func (s Strategy) ForPath(pathHash string) string {
+	return pathHash
+}
+
+// ForFile returns the id of a regular file document with the given path and
+// content hashes
+func (s Strategy) ForFile(pathHash, fileHash string) string {
+	if s == PathIdentity || fileHash == "" {
+		return pathHash
+	}
+	return pathHash + ":" + fileHash
+}
+
+// ForDocument returns the id an existing document should have under this
+// strategy, from its SourcePathHash and FileHash and whether it is a regular
+// file rather than a directory or symlink
+func (s Strategy) ForDocument(pathHash, fileHash string, regular bool) string {
+	if !regular {
+		return s.ForPath(pathHash)
+	}
+	return s.ForFile(pathHash, fileHash)
+}
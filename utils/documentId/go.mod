@@ -0,0 +1,3 @@
+module RSKGroup/OPIe/utils/documentId
+
+go 1.20
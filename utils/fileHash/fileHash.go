@@ -0,0 +1,120 @@
+package fileHash
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// Algorithm is a content hash algorithm, selected with HashAlgo in
+// conf.json. Its name is stored on every file document as FileHashAlgo.
+type Algorithm string
+
+const (
+	// SHA1 is what every index was hashed with before HashAlgo existed
+	SHA1 Algorithm = "sha1"
+	// SHA256 is for indexes that must use an approved cryptographic hash
+	SHA256 Algorithm = "sha256"
+	// BLAKE3 is a cryptographic hash several times faster than SHA-256
+	BLAKE3 Algorithm = "blake3"
+	// XXHash is a non-cryptographic 64-bit hash, for speed over collision resistance
+	XXHash Algorithm = "xxhash"
+)
+
+// ParseAlgorithm reads an algorithm name from configuration. An empty value
+// selects SHA1, so existing configurations keep their hashes.
+func ParseAlgorithm(value string) (Algorithm, error) {
+	switch Algorithm(value) {
+	case "", SHA1:
+		return SHA1, nil
+	case SHA256, BLAKE3, XXHash:
+		return Algorithm(value), nil
+	}
+	return "", fmt.Errorf("unknown hash algorithm %q (want %q, %q, %q or %q)", value, SHA1, SHA256, BLAKE3, XXHash)
+}
+
+// New returns a new hash.Hash computing the algorithm
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New()
+	case BLAKE3:
+		return blake3.New()
+	case XXHash:
+		return xxhash.New()
+	}
+	return sha1.New()
+}
+
+// Sum returns the hex digest of data, such as a path
+func (a Algorithm) Sum(data []byte) string {
+	h := a.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Reader returns the hex digest of everything read from r
+func (a Algorithm) Reader(r io.Reader) (string, error) {
+	h := a.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// File returns the hex digest of a file's contents
+func (a Algorithm) File(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return a.Reader(f)
+}
+
+// Fingerprint returns a quick digest of a large file without reading all of
+// it: the file's size followed by its first, middle and last sampleSize
+// bytes. A file no bigger than the three samples is read whole.
+func (a Algorithm) Fingerprint(filename string, sampleSize int64) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	h := a.New()
+	if err := binary.Write(h, binary.LittleEndian, size); err != nil {
+		return "", err
+	}
+	if size <= 3*sampleSize {
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	} else {
+		for _, offset := range []int64{0, (size - sampleSize) / 2, size - sampleSize} {
+			if _, err := io.Copy(h, io.NewSectionReader(f, offset, sampleSize)); err != nil {
+				return "", err
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// String returns the algorithm's name
+func (a Algorithm) String() string {
+	return string(a)
+}
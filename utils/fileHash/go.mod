@@ -0,0 +1,10 @@
+module RSKGroup/OPIe/utils/fileHash
+
+go 1.20
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0
+	github.com/zeebo/blake3 v0.2.3
+)
+
+require github.com/klauspost/cpuid/v2 v2.0.12 // indirect
@@ -0,0 +1,278 @@
+package fileType
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Coarse categories of file, stored on file documents as FileCategory
+const (
+	Image    = "image"
+	Video    = "video"
+	Audio    = "audio"
+	Document = "document"
+	Archive  = "archive"
+	Code     = "code"
+	Other    = "other"
+)
+
+// How much of a file is read to detect its type. ISO 9660 images have their
+// signature 32 KiB in, so the header has to reach past it.
+const headerSize = 36 << 10
+
+// signature identifies a file type by the bytes at an offset
+type signature struct {
+	offset   int
+	magic    []byte
+	mimeType string
+}
+
+// Signatures checked before falling back to http.DetectContentType, for the
+// office, media, archive and disk image formats it doesn't know
+var signatures = []signature{
+	// Office
+	{0, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), "application/x-ole-storage"},
+	{0, []byte("{\\rtf"), "application/rtf"},
+	// Media
+	{4, []byte("ftypqt  "), "video/quicktime"},
+	{4, []byte("ftypM4A "), "audio/mp4"},
+	{4, []byte("ftypM4V "), "video/x-m4v"},
+	{4, []byte("ftypheic"), "image/heic"},
+	{4, []byte("ftypheix"), "image/heic"},
+	{4, []byte("ftypmif1"), "image/heif"},
+	{4, []byte("ftypavif"), "image/avif"},
+	{4, []byte("ftyp3gp"), "video/3gpp"},
+	{4, []byte("ftyp"), "video/mp4"},
+	{0, []byte("\x1A\x45\xDF\xA3"), "video/x-matroska"},
+	{0, []byte("FLV\x01"), "video/x-flv"},
+	{0, []byte("fLaC"), "audio/flac"},
+	{0, []byte("II*\x00"), "image/tiff"},
+	{0, []byte("MM\x00*"), "image/tiff"},
+	{0, []byte("8BPS"), "image/vnd.adobe.photoshop"},
+	// Archives
+	{0, []byte("7z\xBC\xAF\x27\x1C"), "application/x-7z-compressed"},
+	{0, []byte("Rar!\x1A\x07"), "application/vnd.rar"},
+	{0, []byte("BZh"), "application/x-bzip2"},
+	{0, []byte("\xFD7zXZ\x00"), "application/x-xz"},
+	{0, []byte("\x28\xB5\x2F\xFD"), "application/zstd"},
+	{257, []byte("ustar"), "application/x-tar"},
+	// Disk images
+	{32769, []byte("CD001"), "application/x-iso9660-image"},
+	{0, []byte("conectix"), "application/x-vhd"},
+	{0, []byte("vhdxfile"), "application/x-vhdx"},
+	{0, []byte("KDMV"), "application/x-vmdk"},
+	{0, []byte("QFI\xFB"), "application/x-qemu-disk"},
+	// Executables
+	{0, []byte("\x7FELF"), "application/x-executable"},
+	{0, []byte("\xCF\xFA\xED\xFE"), "application/x-mach-binary"},
+	{0, []byte("\xCE\xFA\xED\xFE"), "application/x-mach-binary"},
+}
+
+// Windows executables start with "MZ", which is too short to tell them from
+// text that happens to. The offset at 0x3C must also lead to a PE header.
+const peOffset = 0x3C
+
+// Types that only say what a file is built from. The extension, when it
+// names a type of the same family, says more.
+var containerTypes = map[string]map[string]string{
+	"application/zip": {
+		"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		"odt":  "application/vnd.oasis.opendocument.text",
+		"ods":  "application/vnd.oasis.opendocument.spreadsheet",
+		"odp":  "application/vnd.oasis.opendocument.presentation",
+		"epub": "application/epub+zip",
+		"jar":  "application/java-archive",
+		"apk":  "application/vnd.android.package-archive",
+	},
+	"application/x-ole-storage": {
+		"doc": "application/msword",
+		"xls": "application/vnd.ms-excel",
+		"ppt": "application/vnd.ms-powerpoint",
+		"msg": "application/vnd.ms-outlook",
+	},
+}
+
+// Types recognised by extension alone, for formats without a usable
+// signature at the start of the file
+var extensionTypes = map[string]string{
+	"dmg":  "application/x-apple-diskimage",
+	"img":  "application/x-raw-disk-image",
+	"md":   "text/markdown",
+	"csv":  "text/csv",
+	"yaml": "application/yaml",
+	"yml":  "application/yaml",
+	"toml": "application/toml",
+}
+
+// Extensions of source code, which is otherwise just text
+var codeExtensions = map[string]bool{
+	"go": true, "py": true, "js": true, "ts": true, "jsx": true, "tsx": true,
+	"java": true, "kt": true, "scala": true, "c": true, "h": true, "cc": true,
+	"cpp": true, "hpp": true, "cs": true, "rs": true, "rb": true, "php": true,
+	"swift": true, "m": true, "sh": true, "bash": true, "zsh": true, "ps1": true,
+	"pl": true, "lua": true, "r": true, "sql": true, "html": true, "htm": true,
+	"css": true, "scss": true, "xml": true, "json": true, "yaml": true,
+	"yml": true, "toml": true, "ini": true, "mod": true, "sum": true,
+}
+
+// Types that hold other files
+var archiveTypes = map[string]bool{
+	"application/zip":                         true,
+	"application/x-gzip":                      true,
+	"application/gzip":                        true,
+	"application/x-7z-compressed":             true,
+	"application/vnd.rar":                     true,
+	"application/x-rar-compressed":            true,
+	"application/x-bzip2":                     true,
+	"application/x-xz":                        true,
+	"application/zstd":                        true,
+	"application/x-tar":                       true,
+	"application/java-archive":                true,
+	"application/vnd.android.package-archive": true,
+	"application/x-iso9660-image":             true,
+	"application/x-apple-diskimage":           true,
+	"application/x-raw-disk-image":            true,
+	"application/x-vhd":                       true,
+	"application/x-vhdx":                      true,
+	"application/x-vmdk":                      true,
+	"application/x-qemu-disk":                 true,
+}
+
+// Types that are documents however they are stored
+var documentTypes = map[string]bool{
+	"application/pdf":               true,
+	"application/postscript":        true,
+	"application/rtf":               true,
+	"application/msword":            true,
+	"application/vnd.ms-excel":      true,
+	"application/vnd.ms-powerpoint": true,
+	"application/vnd.ms-outlook":    true,
+	"application/epub+zip":          true,
+	"text/plain":                    true,
+	"text/markdown":                 true,
+	"text/csv":                      true,
+}
+
+// Detect returns the MIME type of a file from its content, refined by its
+// extension where the content only gives a container format
+func Detect(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return DetectBytes(header[:n], filepath.Ext(filename)), nil
+}
+
+// DetectBytes returns the MIME type of a file from the start of its content
+// and its extension
+func DetectBytes(header []byte, ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if len(header) == 0 {
+		return "inode/x-empty"
+	}
+
+	mimeType := ""
+	for _, sig := range signatures {
+		end := sig.offset + len(sig.magic)
+		if end <= len(header) && bytes.Equal(header[sig.offset:end], sig.magic) {
+			mimeType = sig.mimeType
+			break
+		}
+	}
+	if mimeType == "" && isPortableExecutable(header) {
+		mimeType = "application/vnd.microsoft.portable-executable"
+	}
+	if mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(header))
+	}
+
+	if refined, ok := containerTypes[mimeType][ext]; ok {
+		return refined
+	}
+	// Unrecognised content goes by its extension; text only by an extension
+	// of some other kind of text
+	byExtension := FromExtension(ext)
+	switch {
+	case byExtension == "":
+	case mimeType == "application/octet-stream":
+		return byExtension
+	case mimeType == "text/plain" && isText(byExtension):
+		return byExtension
+	}
+	return mimeType
+}
+
+// Whether a header is that of a PE executable: "MZ", and at the offset
+// stored at 0x3C, "PE\0\0"
+func isPortableExecutable(header []byte) bool {
+	if len(header) < peOffset+4 || !bytes.HasPrefix(header, []byte("MZ")) {
+		return false
+	}
+	offset := int64(binary.LittleEndian.Uint32(header[peOffset:]))
+	return offset+4 <= int64(len(header)) && bytes.Equal(header[offset:offset+4], []byte("PE\x00\x00"))
+}
+
+// Whether a MIME type is a kind of text
+func isText(mimeType string) bool {
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+	switch mimeType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/yaml", "application/toml", "application/x-sh":
+		return true
+	}
+	return strings.HasSuffix(mimeType, "+xml") || strings.HasSuffix(mimeType, "+json")
+}
+
+// FromExtension returns the MIME type usually given to an extension, or ""
+// if it is unknown
+func FromExtension(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if ext == "" {
+		return ""
+	}
+	if mimeType, ok := extensionTypes[ext]; ok {
+		return mimeType
+	}
+	mimeType, _, _ := mime.ParseMediaType(mime.TypeByExtension("." + ext))
+	return mimeType
+}
+
+// Category returns the coarse category of a file from its MIME type and
+// extension
+func Category(mimeType, ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	switch {
+	case codeExtensions[ext] && (strings.HasPrefix(mimeType, "text/") || strings.HasPrefix(mimeType, "application/")):
+		return Code
+	case strings.HasPrefix(mimeType, "image/"):
+		return Image
+	case strings.HasPrefix(mimeType, "video/"):
+		return Video
+	case strings.HasPrefix(mimeType, "audio/"):
+		return Audio
+	case archiveTypes[mimeType]:
+		return Archive
+	case documentTypes[mimeType],
+		strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument."):
+		return Document
+	}
+	return Other
+}
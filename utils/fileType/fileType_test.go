@@ -0,0 +1,63 @@
+package fileType
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Bytes with magic at offset, zero-filled before it
+func at(offset int, magic string) []byte {
+	header := make([]byte, offset+len(magic))
+	copy(header[offset:], magic)
+	return header
+}
+
+// A PE executable's header, with the PE signature where 0x3C says it is
+func peHeader(offset uint32, signature string) []byte {
+	header := make([]byte, 0x200)
+	copy(header, "MZ")
+	binary.LittleEndian.PutUint32(header[peOffset:], offset)
+	copy(header[offset:], signature)
+	return header
+}
+
+func TestDetectBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		ext    string
+		want   string
+	}{
+		{"empty", nil, "txt", "inode/x-empty"},
+		{"ole", at(0, "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), "", "application/x-ole-storage"},
+		{"ole doc", at(0, "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"), "doc", "application/msword"},
+		{"rtf", at(0, "{\\rtf1"), "", "application/rtf"},
+		{"quicktime", at(4, "ftypqt  "), "", "video/quicktime"},
+		{"heic", at(4, "ftypheic"), "", "image/heic"},
+		{"mp4", at(4, "ftypisom"), "", "video/mp4"},
+		{"matroska", at(0, "\x1A\x45\xDF\xA3"), "", "video/x-matroska"},
+		{"flac", at(0, "fLaC"), "", "audio/flac"},
+		{"tiff little endian", at(0, "II*\x00"), "", "image/tiff"},
+		{"tiff big endian", at(0, "MM\x00*"), "", "image/tiff"},
+		{"7z", at(0, "7z\xBC\xAF\x27\x1C"), "", "application/x-7z-compressed"},
+		{"tar", at(257, "ustar"), "", "application/x-tar"},
+		{"iso", at(32769, "CD001"), "", "application/x-iso9660-image"},
+		{"vhdx", at(0, "vhdxfile"), "", "application/x-vhdx"},
+		{"elf", at(0, "\x7FELF"), "", "application/x-executable"},
+		{"mach-o", at(0, "\xCF\xFA\xED\xFE"), "", "application/x-mach-binary"},
+		{"pe", peHeader(0x80, "PE\x00\x00"), "exe", "application/vnd.microsoft.portable-executable"},
+		{"mz without pe header", peHeader(0x80, "NE\x00\x00"), "", "application/octet-stream"},
+		{"mz with pe offset past header", peHeader(0x80, "PE\x00\x00")[:0x80], "", "application/octet-stream"},
+		{"text starting with mz", []byte("MZ is where the notes for the next release go.\n"), "txt", "text/plain"},
+		{"short text starting with mz", []byte("MZ\n"), "", "text/plain"},
+		{"markdown", []byte("# Title\n"), "md", "text/markdown"},
+		{"zip docx", at(0, "PK\x03\x04"), "docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DetectBytes(test.header, test.ext); got != test.want {
+				t.Errorf("DetectBytes(%q) = %q, want %q", test.ext, got, test.want)
+			}
+		})
+	}
+}
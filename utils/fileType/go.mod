@@ -0,0 +1,3 @@
+module RSKGroup/OPIe/utils/fileType
+
+go 1.20
@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -135,6 +136,31 @@ type Watcher struct {
 	done        chan struct{} // Channel for sending a "quit message" to the reader goroutine
 	closeMu     sync.Mutex
 	doneResp    chan struct{} // Channel to respond to Close
+
+	// The last few IN_MOVED_FROM events, to pair with their IN_MOVED_TO;
+	// only used from readEvents.
+	cookies     [10]koekje
+	cookieIndex uint8
+
+	// Directories created in or moved into a recursive watch, queued by
+	// readEvents for walkDirs, so a large tree being moved in doesn't hold
+	// up reading the events.
+	walkMu    sync.Mutex
+	walkQueue []newDir
+	walkWake  chan struct{} // Signals walkDirs there's something queued
+	walkDone  chan struct{} // Closed when walkDirs returns
+}
+
+// newDir is a directory to be walked and watched as part of a recursive watch.
+type newDir struct {
+	path string
+	skip func(path string) bool
+}
+
+// koekje is the path an IN_MOVED_FROM event with a cookie was sent for.
+type koekje struct {
+	cookie uint32
+	path   string
 }
 
 type (
@@ -147,6 +173,11 @@ type (
 		wd    uint32 // Watch descriptor (as returned by the inotify_add_watch() syscall)
 		flags uint32 // inotify flags of this watch (see inotify(7) for the list of valid flags)
 		path  string // Watch path.
+
+		recurse bool                   // Part of a recursive watch.
+		child   bool                   // Subdirectory of a recursive watch, rather than added with Add().
+		moved   bool                   // Renamed within its recursive watch; its IN_MOVE_SELF is expected.
+		skip    func(path string) bool // Subdirectories of a recursive watch not to watch; may be nil.
 	}
 )
 
@@ -192,6 +223,46 @@ func (w *watches) removePath(path string) (uint32, bool) {
 	return wd, true
 }
 
+// recursive returns the paths of the recursive watch on root and of every
+// subdirectory watched as part of it.
+func (w *watches) recursive(root string) []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var paths []string
+	for path, wd := range w.path {
+		if w.wd[wd].recurse && (path == root || strings.HasPrefix(path, root+"/")) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// rename updates the paths of a subdirectory of a recursive watch, and of
+// everything watched below it, after it was renamed to newPath. It returns
+// false if oldPath isn't watched as part of a recursive watch.
+func (w *watches) rename(oldPath, newPath string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wd, ok := w.path[oldPath]
+	if !ok || !w.wd[wd].child {
+		return false
+	}
+	w.wd[wd].moved = true
+
+	for path, wd := range w.path {
+		if path != oldPath && !strings.HasPrefix(path, oldPath+"/") {
+			continue
+		}
+		ww := w.wd[wd]
+		ww.path = newPath + path[len(oldPath):]
+		delete(w.path, path)
+		w.path[ww.path] = wd
+	}
+	return true
+}
+
 func (w *watches) byPath(path string) *watch {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
@@ -247,9 +318,12 @@ func NewWatcher() (*Watcher, error) {
 		Errors:      make(chan error),
 		done:        make(chan struct{}),
 		doneResp:    make(chan struct{}),
+		walkWake:    make(chan struct{}, 1),
+		walkDone:    make(chan struct{}),
 	}
 
 	go w.readEvents()
+	go w.walkDirs()
 	return w, nil
 }
 
@@ -349,14 +423,55 @@ func (w *Watcher) Add(name string) error { return w.AddWith(name) }
 //
 //   - [WithBufferSize] sets the buffer size for the Windows backend; no-op on
 //     other platforms. The default is 64K (65536 bytes).
+//   - [WithSkipDir] leaves subdirectories of a recursive watch unwatched;
+//     inotify only.
 func (w *Watcher) AddWith(name string, opts ...addOpt) error {
 	if w.isClosed() {
 		return ErrClosed
 	}
 
+	with := getOptions(opts...)
+
+	name, recurse := recursivePath(name)
 	name = filepath.Clean(name)
-	_ = getOptions(opts...)
+	if recurse {
+		return w.addRecursive(name, false, with.skipDir, nil)
+	}
+	return w.add(name, false, false, nil)
+}
+
+// addRecursive adds a recursive watch on root and every directory below it,
+// except for the subdirectories skip returns true for and what's below them.
+//
+// Unless found is nil it's called with every path below root; for a
+// directory that's before its watch is added, and its entries are listed
+// after, so nothing created in it in the meantime is missed.
+func (w *Watcher) addRecursive(root string, child bool, skip func(string) bool, found func(path string, d fs.DirEntry) bool) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != root && errors.Is(err, fs.ErrNotExist) {
+				return nil // Removed since it was listed.
+			}
+			return err
+		}
+		if path != root && found != nil && !found(path, d) {
+			return ErrClosed
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if (child || path != root) && skip != nil && skip(path) {
+			return filepath.SkipDir
+		}
+		err = w.add(path, true, child || path != root, skip)
+		if path != root && errors.Is(err, unix.ENOENT) {
+			return filepath.SkipDir
+		}
+		return err
+	})
+}
 
+func (w *Watcher) add(name string, recurse, child bool, skip func(string) bool) error {
 	var flags uint32 = unix.IN_MOVED_TO | unix.IN_MOVED_FROM |
 		unix.IN_CREATE | unix.IN_ATTRIB | unix.IN_MODIFY |
 		unix.IN_MOVE_SELF | unix.IN_DELETE | unix.IN_DELETE_SELF
@@ -373,14 +488,20 @@ func (w *Watcher) AddWith(name string, opts ...addOpt) error {
 
 		if existing == nil {
 			return &watch{
-				wd:    uint32(wd),
-				path:  name,
-				flags: flags,
+				wd:      uint32(wd),
+				path:    name,
+				flags:   flags,
+				recurse: recurse,
+				child:   child,
+				skip:    skip,
 			}, nil
 		}
 
 		existing.wd = uint32(wd)
 		existing.flags = flags
+		if recurse && !existing.recurse {
+			existing.recurse, existing.child, existing.skip = true, child, skip
+		}
 		return existing, nil
 	})
 }
@@ -404,7 +525,35 @@ func (w *Watcher) Remove(name string) error {
 	if w.isClosed() {
 		return nil
 	}
-	return w.remove(filepath.Clean(name))
+
+	name, recurse := recursivePath(name)
+	name = filepath.Clean(name)
+
+	watch := w.watches.byPath(name)
+	if watch == nil || watch.child {
+		return fmt.Errorf("%w: %s", ErrNonExistentWatch, name)
+	}
+	if recurse && !watch.recurse {
+		return fmt.Errorf("can't use /... with non-recursive watch %q", name)
+	}
+	if watch.recurse {
+		return w.removeRecursive(name)
+	}
+	return w.remove(name)
+}
+
+// removeRecursive removes the recursive watch on root, or on a subdirectory
+// of one, along with the watches of every directory below it.
+func (w *Watcher) removeRecursive(root string) error {
+	var err error
+	for _, path := range w.watches.recursive(root) {
+		rmErr := w.remove(path)
+		// Watches of directories deleted in the meantime are already gone.
+		if rmErr != nil && err == nil && !errors.Is(rmErr, ErrNonExistentWatch) && !errors.Is(rmErr, unix.EINVAL) {
+			err = rmErr
+		}
+	}
+	return err
 }
 
 func (w *Watcher) remove(name string) error {
@@ -440,7 +589,10 @@ func (w *Watcher) WatchList() []string {
 
 	entries := make([]string, 0, w.watches.len())
 	w.watches.mu.RLock()
-	for pathname := range w.watches.path {
+	for pathname, wd := range w.watches.path {
+		if w.watches.wd[wd].child {
+			continue
+		}
 		entries = append(entries, pathname)
 	}
 	w.watches.mu.RUnlock()
@@ -452,6 +604,7 @@ func (w *Watcher) WatchList() []string {
 // received events into Event objects and sends them via the Events channel
 func (w *Watcher) readEvents() {
 	defer func() {
+		<-w.walkDone // Stops sending once w.done is closed.
 		close(w.doneResp)
 		close(w.Errors)
 		close(w.Events)
@@ -516,21 +669,41 @@ func (w *Watcher) readEvents() {
 			// the "paths" map.
 			watch := w.watches.byWd(uint32(raw.Wd))
 
+			// The subdirectories of a recursive watch are also entries of the
+			// directory above them, whose watch already sends the events for
+			// them being removed or renamed.
+			skip := false
+
 			// inotify will automatically remove the watch on deletes; just need
 			// to clean our state here.
 			if watch != nil && mask&unix.IN_DELETE_SELF == unix.IN_DELETE_SELF {
 				w.watches.remove(watch.wd)
+				skip = watch.child
 			}
 			// We can't really update the state when a watched path is moved;
 			// only IN_MOVE_SELF is sent and not IN_MOVED_{FROM,TO}. So remove
-			// the watch.
+			// the watch. A subdirectory renamed within its recursive watch was
+			// already updated when the IN_MOVED_TO was read, and keeps it.
 			if watch != nil && mask&unix.IN_MOVE_SELF == unix.IN_MOVE_SELF {
-				err := w.remove(watch.path)
+				w.watches.mu.Lock()
+				moved := watch.moved
+				watch.moved = false
+				w.watches.mu.Unlock()
+
+				var err error
+				switch {
+				case moved:
+				case watch.recurse:
+					err = w.removeRecursive(watch.path)
+				default:
+					err = w.remove(watch.path)
+				}
 				if err != nil && !errors.Is(err, ErrNonExistentWatch) {
 					if !w.sendError(err) {
 						return
 					}
 				}
+				skip = watch.child
 			}
 
 			var name string
@@ -546,19 +719,99 @@ func (w *Watcher) readEvents() {
 
 			event := w.newEvent(name, mask)
 
+			// The two halves of a rename share a cookie, and the kernel sends
+			// them one after the other, so only a few need to be remembered.
+			if mask&unix.IN_MOVED_FROM == unix.IN_MOVED_FROM {
+				w.cookies[w.cookieIndex] = koekje{cookie: raw.Cookie, path: name}
+				w.cookieIndex = (w.cookieIndex + 1) % uint8(len(w.cookies))
+			} else if mask&unix.IN_MOVED_TO == unix.IN_MOVED_TO {
+				for i, c := range w.cookies {
+					if c.cookie == raw.Cookie && c.path != "" {
+						event.RenamedFrom = c.path
+						w.cookies[i] = koekje{}
+						break
+					}
+				}
+			}
+
 			// Send the events that are not ignored on the events channel
-			if mask&unix.IN_IGNORED == 0 {
+			if mask&unix.IN_IGNORED == 0 && !skip {
 				if !w.sendEvent(event) {
 					return
 				}
 			}
 
+			// A directory created in or moved into a recursive watch is
+			// watched too, unless it's skipped. One renamed within the
+			// recursive watch keeps its watches, unless it's now skipped.
+			isDir := mask&unix.IN_ISDIR == unix.IN_ISDIR
+			if watch != nil && watch.recurse && isDir && event.Has(Create) {
+				skipped := watch.skip != nil && watch.skip(name)
+				switch {
+				case event.RenamedFrom != "" && w.watches.rename(event.RenamedFrom, name):
+					if skipped {
+						err := w.removeRecursive(name)
+						if err != nil && !w.sendError(err) {
+							return
+						}
+					}
+				case !skipped:
+					w.queueDir(newDir{path: name, skip: watch.skip})
+				}
+			}
+
 			// Move to the next event in the buffer
 			offset += unix.SizeofInotifyEvent + nameLen
 		}
 	}
 }
 
+// queueDir queues a directory for walkDirs.
+func (w *Watcher) queueDir(dir newDir) {
+	w.walkMu.Lock()
+	w.walkQueue = append(w.walkQueue, dir)
+	w.walkMu.Unlock()
+
+	select {
+	case w.walkWake <- struct{}{}:
+	default:
+	}
+}
+
+// walkDirs watches the directories queued by readEvents, in the order they
+// were queued. Anything created in them before their watch was added is sent
+// as a Create, so there may be duplicates.
+func (w *Watcher) walkDirs() {
+	defer close(w.walkDone)
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.walkWake:
+		}
+
+		w.walkMu.Lock()
+		queue := w.walkQueue
+		w.walkQueue = nil
+		w.walkMu.Unlock()
+
+		for _, dir := range queue {
+			err := w.addRecursive(dir.path, true, dir.skip, func(path string, d fs.DirEntry) bool {
+				return w.sendEvent(Event{Name: path, Op: Create})
+			})
+			if w.isClosed() {
+				return
+			}
+			if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, unix.ENOENT) {
+				if !w.sendError(err) {
+					return
+				}
+			}
+		}
+	}
+}
+
 // newEvent returns an platform-independent Event based on an inotify mask.
 func (w *Watcher) newEvent(name string, mask uint32) Event {
 	e := Event{Name: name}
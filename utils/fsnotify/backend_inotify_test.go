@@ -6,6 +6,7 @@ package fsnotify
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -134,3 +135,151 @@ func TestRemoveState(t *testing.T) {
 	}
 	check(0)
 }
+
+// The Create sent for the new name of a rename should say where it came from.
+func TestInotifyRenamedFrom(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdir(t, tmp, "dir")
+	touch(t, tmp, "file")
+
+	w := newCollector(t, tmp)
+	addWatch(t, w.w, tmp, "dir")
+	w.collect(t)
+
+	mv(t, join(tmp, "file"), tmp, "renamed")
+	eventSeparator()
+	mv(t, join(tmp, "renamed"), tmp, "dir", "moved")
+	eventSeparator()
+	touch(t, tmp, "new")
+
+	renamedFrom := make(map[string]string)
+	for _, e := range w.stop(t) {
+		if e.Has(Create) {
+			renamedFrom[e.Name] = e.RenamedFrom
+		}
+	}
+	want := map[string]string{
+		join(tmp, "renamed"):      join(tmp, "file"),
+		join(tmp, "dir", "moved"): join(tmp, "renamed"),
+		join(tmp, "new"):          "",
+	}
+	for name, from := range want {
+		have, ok := renamedFrom[name]
+		if !ok {
+			t.Errorf("no Create event for %q", name)
+			continue
+		}
+		if have != from {
+			t.Errorf("RenamedFrom of %q: have %q, want %q", name, have, from)
+		}
+	}
+}
+
+// Directories should be watched as they come and go in a recursive watch,
+// and everything put in a new directory before its watch was added should be
+// sent as a Create.
+func TestInotifyRecursiveWatches(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	outside := t.TempDir()
+	mkdirAll(t, tmp, "one", "two")
+	mkdirAll(t, outside, "in", "deep")
+	touch(t, outside, "in", "deep", "file")
+
+	w := newCollector(t)
+	addWatch(t, w.w, tmp, "...")
+	w.collect(t)
+
+	check := func(want int) {
+		t.Helper()
+		waitForEvents()
+		if have := w.w.watches.len(); have != want {
+			t.Errorf("have %d watches, want %d: %v", have, want, w.w.watches.recursive(tmp))
+		}
+	}
+	check(3)
+
+	// Created with everything below it before the events are read.
+	mkdirAll(t, tmp, "new", "a", "b")
+	touch(t, tmp, "new", "a", "b", "file")
+	check(6)
+
+	mv(t, join(outside, "in"), tmp, "one", "in")
+	check(8)
+	mv(t, join(tmp, "one", "in"), tmp, "moved")
+	check(8)
+	mv(t, join(tmp, "moved"), outside, "out")
+	check(6)
+	rmAll(t, tmp, "new")
+	check(3)
+
+	touch(t, tmp, "one", "two", "file")
+	if l := w.w.WatchList(); len(l) != 1 || l[0] != tmp {
+		t.Errorf("WatchList: %v", l)
+	}
+
+	have := make(map[string]bool)
+	for _, e := range w.stop(t) {
+		if e.Has(Create) {
+			have[strings.TrimPrefix(e.Name, tmp)] = true
+		}
+	}
+	for _, name := range []string{
+		"/new", "/new/a", "/new/a/b", "/new/a/b/file",
+		"/one/in", "/one/in/deep", "/one/in/deep/file",
+		"/moved", "/one/two/file",
+	} {
+		if !have[name] {
+			t.Errorf("no Create event for %q", name)
+		}
+	}
+}
+
+// Directories skipped with WithSkipDir, and everything below them, shouldn't
+// be watched, whether they were there first, created, or renamed to.
+func TestInotifyRecursiveSkipDir(t *testing.T) {
+	t.Parallel()
+
+	tmp := t.TempDir()
+	mkdirAll(t, tmp, "skip", "below")
+	mkdirAll(t, tmp, "keep")
+
+	w := newCollector(t)
+	err := w.w.AddWith(join(tmp, "..."), WithSkipDir(func(path string) bool {
+		return strings.HasPrefix(filepath.Base(path), "skip")
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.collect(t)
+
+	check := func(want int) {
+		t.Helper()
+		waitForEvents()
+		if have := w.w.watches.len(); have != want {
+			t.Errorf("have %d watches, want %d: %v", have, want, w.w.watches.recursive(tmp))
+		}
+	}
+	check(2)
+
+	mkdirAll(t, tmp, "keep", "skip", "below")
+	check(2)
+	mkdirAll(t, tmp, "keep", "new")
+	check(3)
+	mv(t, join(tmp, "keep", "new"), tmp, "keep", "skip2")
+	check(2)
+	mv(t, join(tmp, "keep", "skip2"), tmp, "keep", "back")
+	check(3)
+
+	touch(t, tmp, "skip", "below", "file")
+	touch(t, tmp, "keep", "file")
+	for _, e := range w.stop(t) {
+		if strings.HasPrefix(e.Name, join(tmp, "skip")+"/") ||
+			strings.HasPrefix(e.Name, join(tmp, "keep", "skip")+"/") {
+			t.Errorf("event below a skipped directory: %s", e)
+		}
+	}
+}
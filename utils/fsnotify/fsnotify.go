@@ -30,6 +30,14 @@ type Event struct {
 	// This is a bitmask and some systems may send multiple operations at once.
 	// Use the Event.Has() method instead of comparing with ==.
 	Op Op
+
+	// Path the file or directory was renamed from, for the Create event sent
+	// with the new name of a rename within the watched paths.
+	//
+	// This is only set by the inotify backend, which pairs the two halves of
+	// a rename by their cookie. It's empty everywhere else, and for a path
+	// moved in from outside the watched paths.
+	RenamedFrom string
 }
 
 // Op describes a set of file operations.
@@ -100,6 +108,9 @@ func (e Event) Has(op Op) bool { return e.Op.Has(op) }
 
 // String returns a string representation of the event with their path.
 func (e Event) String() string {
+	if e.RenamedFrom != "" {
+		return fmt.Sprintf("%-13s %q ← %q", e.Op.String(), e.Name, e.RenamedFrom)
+	}
 	return fmt.Sprintf("%-13s %q", e.Op.String(), e.Name)
 }
 
@@ -107,6 +118,7 @@ type (
 	addOpt   func(opt *withOpts)
 	withOpts struct {
 		bufsize int
+		skipDir func(path string) bool
 	}
 )
 
@@ -133,6 +145,17 @@ func WithBufferSize(bytes int) addOpt {
 	return func(opt *withOpts) { opt.bufsize = bytes }
 }
 
+// WithSkipDir sets a function that's called with every subdirectory of a
+// recursive watch, including ones created later; the directories it returns
+// true for, and everything below them, aren't watched. Events for entries of
+// a watched directory, such as the skipped directory itself, are still sent.
+//
+// This is only supported by the inotify backend, and is a no-op for other
+// backends.
+func WithSkipDir(skip func(path string) bool) addOpt {
+	return func(opt *withOpts) { opt.skipDir = skip }
+}
+
 // Check if this path is recursive (ends with "/..." or "\..."), and return the
 // path with the /... stripped.
 func recursivePath(path string) (string, bool) {
@@ -794,6 +794,12 @@ func TestWatchRemove(t *testing.T) {
 			write /dir1/subdir/file
 			write /dir2/subdir
 			write /dir2/subdir/file
+
+			# inotify doesn't send a Write for a directory when a file in it
+			# changes.
+			linux:
+				write /dir1/subdir/file
+				write /dir2/subdir/file
 		`},
 	}
 
@@ -821,6 +827,12 @@ func TestWatchRecursive(t *testing.T) {
 			write     /one/two/three             # cat asd >one/two/three/file.txt
 			create    /one/two/three/file.txt
 			write     /one/two/three/file.txt
+
+			linux:
+				create    /file.txt
+				write     /file.txt
+				create    /one/two/three/file.txt
+				write     /one/two/three/file.txt
 		`},
 
 		// Create a new directory tree and then some files under that.
@@ -840,6 +852,14 @@ func TestWatchRecursive(t *testing.T) {
 			create    /one/two/new/file
 
 			create    /one/two/new/dir/file   # touch one/two/new/dir/file
+
+			# new/dir is sent when new's watch is added and it is already
+			# there, or by that watch when it isn't yet.
+			linux:
+				create    /one/two/new
+				create    /one/two/new/dir
+				create    /one/two/new/file
+				create    /one/two/new/dir/file
 		`},
 
 		// Remove nested directory
@@ -862,6 +882,14 @@ func TestWatchRecursive(t *testing.T) {
 			remove               /one/two/three
 			write                /one/two
 			remove               /one/two
+
+			linux:
+				create               /one/two/three/file.txt
+				write                /one/two/three/file.txt
+				remove               /one/two/three/file.txt
+				remove               /one/two/three/four
+				remove               /one/two/three
+				remove               /one/two
 		`},
 
 		// Rename nested directory
@@ -881,6 +909,14 @@ func TestWatchRecursive(t *testing.T) {
 
 			write                "/one-rename/two/three"       # touch one-rename/two/three/file
 			create               "/one-rename/two/three/file"
+
+			# The watches below the renamed directory are kept, under the new
+			# name.
+			linux:
+				rename               "/one"
+				create               "/one-rename"
+				create               "/one-rename/file"
+				create               "/one-rename/two/three/file"
 		`},
 
 		{"remove watched directory", func(t *testing.T, w *Watcher, tmp string) {
@@ -949,6 +985,36 @@ func TestWatchRecursive(t *testing.T) {
 			write                "/sub"
 			remove               "/sub"
 			remove               "/"
+
+			linux:
+				remove               "/a"
+				remove               "/b"
+				remove               "/c"
+				remove               "/d"
+				remove               "/e"
+				remove               "/f"
+				remove               "/g"
+				remove               "/h/a"
+				remove               "/h"
+				remove               "/i/a"
+				remove               "/i"
+				remove               "/j/a"
+				remove               "/j"
+				remove               "/sub/a"
+				remove               "/sub/b"
+				remove               "/sub/c"
+				remove               "/sub/d"
+				remove               "/sub/e"
+				remove               "/sub/f"
+				remove               "/sub/g"
+				remove               "/sub/h/a"
+				remove               "/sub/h"
+				remove               "/sub/i/a"
+				remove               "/sub/i"
+				remove               "/sub/j/a"
+				remove               "/sub/j"
+				remove               "/sub"
+				remove               "/"
 		`},
 	}
 
@@ -1326,16 +1392,18 @@ func TestEventString(t *testing.T) {
 		want string
 	}{
 		{Event{}, `[no events]   ""`},
-		{Event{"/file", 0}, `[no events]   "/file"`},
+		{Event{Name: "/file"}, `[no events]   "/file"`},
 
-		{Event{"/file", Chmod | Create},
+		{Event{Name: "/file", Op: Chmod | Create},
 			`CREATE|CHMOD  "/file"`},
-		{Event{"/file", Rename},
+		{Event{Name: "/file", Op: Rename},
 			`RENAME        "/file"`},
-		{Event{"/file", Remove},
+		{Event{Name: "/file", Op: Remove},
 			`REMOVE        "/file"`},
-		{Event{"/file", Write | Chmod},
+		{Event{Name: "/file", Op: Write | Chmod},
 			`WRITE|CHMOD   "/file"`},
+		{Event{Name: "/file", Op: Create, RenamedFrom: "/old"},
+			`CREATE        "/file" ← "/old"`},
 	}
 
 	for _, tt := range tests {
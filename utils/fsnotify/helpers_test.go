@@ -583,7 +583,7 @@ func isSolaris() bool {
 
 func recurseOnly(t *testing.T) {
 	switch runtime.GOOS {
-	case "windows":
+	case "windows", "linux":
 		// Run test.
 	default:
 		t.Skip("recursion not yet supported on " + runtime.GOOS)
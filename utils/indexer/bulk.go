@@ -0,0 +1,222 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bulkWriter accumulates upserts and sends them to MongoDB as unordered
+// BulkWrite calls, either when batchSize documents are pending or when the
+// flush interval elapses. Failed documents are passed to onFailure and
+// counted; they never abort the rest of their batch, but the next Flush
+// returns an error.
+//
+// One batch is written at a time, and writing pending documents waits for
+// the batch in flight, so writes land in the order they were queued and
+// whatever is done after a flush follows everything queued before it. A
+// batch is split where the context changes, and before a document already
+// in it, as an unordered BulkWrite may apply its writes in any order; each
+// part is written with the context its writes were queued with, so
+// cancelling them aborts it.
+type bulkWriter struct {
+	collection *mongo.Collection
+	batchSize  int
+	// Called with every document that failed to write; when nil they are
+	// only logged
+	onFailure func(write queuedWrite, err error)
+
+	// Held while a batch is written
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending []pendingWrite
+	written int64
+	failed  int64
+	// Documents that failed since the last Flush
+	unflushed int64
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// queuedWrite identifies a pending write when it fails
+type queuedWrite struct {
+	ID          string
+	Path        string
+	IsDirectory bool
+}
+
+// pendingWrite is a write model waiting for the next batch
+type pendingWrite struct {
+	ctx   context.Context
+	model mongo.WriteModel
+	write queuedWrite
+}
+
+// Create a bulk writer and start its periodic flusher
+func newBulkWriter(collection *mongo.Collection, batchSize int, flushInterval time.Duration) *bulkWriter {
+	w := &bulkWriter{
+		collection: collection,
+		batchSize:  batchSize,
+		stop:       make(chan struct{}),
+	}
+	if flushInterval > 0 {
+		w.stopped.Add(1)
+		go w.flushEvery(flushInterval)
+	}
+	return w
+}
+
+// Flush pending writes on a timer until the writer is closed
+func (w *bulkWriter) flushEvery(interval time.Duration) {
+	defer w.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.writePending()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Queue an upsert of the document, flushing if the batch is full
+func (w *bulkWriter) Upsert(ctx context.Context, doc mongoWrite.Document) {
+	model := mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"_id": doc.ID()}).
+		SetUpdate(bson.M{"$set": doc}).
+		SetUpsert(true)
+	sourceFile, _ := doc["SourceFile"].(string)
+	w.Add(ctx, model, queuedWrite{ID: doc.ID(), Path: sourceFile, IsDirectory: doc.Bool("IsDirectory")})
+}
+
+// Queue any write model, flushing if the batch is full. The write is only
+// used to report failures.
+func (w *bulkWriter) Add(ctx context.Context, model mongo.WriteModel, write queuedWrite) {
+	w.mu.Lock()
+	w.pending = append(w.pending, pendingWrite{ctx: ctx, model: model, write: write})
+	full := len(w.pending) >= w.batchSize
+	w.mu.Unlock()
+
+	if full {
+		w.writePending()
+	}
+}
+
+// Flush sends all pending writes to MongoDB. It returns an error if any
+// document failed to write since the last Flush, including in the batches
+// sent on their own in the meantime.
+func (w *bulkWriter) Flush() error {
+	w.writePending()
+
+	w.mu.Lock()
+	failed := w.unflushed
+	w.unflushed = 0
+	w.mu.Unlock()
+
+	if failed > 0 {
+		return fmt.Errorf("%d documents failed to write to %s", failed, w.collection.Name())
+	}
+	return nil
+}
+
+// Send all pending writes to MongoDB, after the batch being written if there
+// is one
+func (w *bulkWriter) writePending() {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+
+	for len(pending) > 0 {
+		n := batchEnd(pending)
+		w.writeBatch(pending[:n])
+		pending = pending[n:]
+	}
+}
+
+// How many of the pending writes can go in one BulkWrite: up to the first
+// with another context, or for a document already in the batch
+func batchEnd(pending []pendingWrite) int {
+	ids := make(map[string]bool, len(pending))
+	for i, p := range pending {
+		if i > 0 && (p.ctx != pending[0].ctx || ids[p.write.ID]) {
+			return i
+		}
+		ids[p.write.ID] = true
+	}
+	return len(pending)
+}
+
+// Write one batch with the context of its writes
+func (w *bulkWriter) writeBatch(batch []pendingWrite) {
+	ctx := batch[0].ctx
+	models := make([]mongo.WriteModel, len(batch))
+	writes := make([]queuedWrite, len(batch))
+	for i, p := range batch {
+		models[i], writes[i] = p.model, p.write
+	}
+
+	failed := 0
+	opts := options.BulkWrite().SetOrdered(false)
+	_, err := w.collection.BulkWrite(ctx, models, opts)
+	if err != nil {
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+			// Report each rejected document; the rest of the batch was applied
+			for _, writeErr := range bulkErr.WriteErrors {
+				w.fail(writes[writeErr.Index], errors.New(writeErr.Message))
+			}
+			failed = len(bulkErr.WriteErrors)
+		} else {
+			for _, write := range writes {
+				w.fail(write, err)
+			}
+			failed = len(models)
+		}
+	}
+
+	w.mu.Lock()
+	w.written += int64(len(models) - failed)
+	w.failed += int64(failed)
+	w.unflushed += int64(failed)
+	w.mu.Unlock()
+}
+
+// Report a document that failed to write
+func (w *bulkWriter) fail(write queuedWrite, err error) {
+	if w.onFailure != nil {
+		w.onFailure(write, err)
+		return
+	}
+	log.Printf("Failed to write document %s: %v\n", write.ID, err)
+}
+
+// Stop the flusher, write anything still pending and report failures
+func (w *bulkWriter) Close() error {
+	close(w.stop)
+	w.stopped.Wait()
+	w.writePending()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	log.Printf("Bulk writer for %s: %d documents written, %d failed\n", w.collection.Name(), w.written, w.failed)
+	if w.failed > 0 {
+		return fmt.Errorf("%d documents failed to write", w.failed)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+)
+
+type ctxKey struct{}
+
+func TestBatchEnd(t *testing.T) {
+	first := context.Background()
+	second := context.WithValue(first, ctxKey{}, "second")
+	write := func(ctx context.Context, id string) pendingWrite {
+		return pendingWrite{ctx: ctx, write: queuedWrite{ID: id}}
+	}
+
+	tests := []struct {
+		name    string
+		pending []pendingWrite
+		want    int
+	}{
+		{"one", []pendingWrite{write(first, "a")}, 1},
+		{"same context", []pendingWrite{write(first, "a"), write(first, "b"), write(first, "c")}, 3},
+		{"context changes", []pendingWrite{write(first, "a"), write(first, "b"), write(second, "c")}, 2},
+		{"repeated document", []pendingWrite{write(first, "a"), write(first, "b"), write(first, "a")}, 2},
+		{"repeated later", []pendingWrite{write(second, "a"), write(second, "b"), write(first, "a")}, 2},
+	}
+	for _, tt := range tests {
+		if have := batchEnd(tt.pending); have != tt.want {
+			t.Errorf("%s: have %d, want %d", tt.name, have, tt.want)
+		}
+	}
+}
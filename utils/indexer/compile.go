@@ -0,0 +1,307 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"RSKGroup/OPIe/utils/fileHash"
+	"RSKGroup/OPIe/utils/fileType"
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"RSKGroup/OPIe/utils/symlink"
+)
+
+// DirectoryTotals are the counts and sizes of a directory's immediate
+// children and of its whole subtree. They are built bottom-up as each child
+// is finished, so every directory is read exactly once.
+type DirectoryTotals struct {
+	ChildDirs       int64
+	ChildFiles      int64
+	ChildSize       int64
+	DescendantDirs  int64
+	DescendantFiles int64
+	DescendantSize  int64
+}
+
+// Add folds a child entry, and the subtree below it when it is a directory,
+// into its parent's totals
+func (t *DirectoryTotals) Add(childInfo os.FileInfo, child DirectoryTotals) {
+	if childInfo.IsDir() && !isSymbolicLink(childInfo) {
+		t.ChildDirs++
+		t.DescendantDirs += 1 + child.DescendantDirs
+		t.DescendantFiles += child.DescendantFiles
+		t.DescendantSize += child.DescendantSize
+		return
+	}
+	t.ChildFiles++
+	t.ChildSize += childInfo.Size()
+	t.DescendantFiles++
+	t.DescendantSize += childInfo.Size()
+}
+
+// FileContent holds the content-derived parts of a regular file's record,
+// filled in by DetectType, Hash and ExtractMetadata before the record is
+// compiled. Link is set for a symbolic link the caller resolved, which for
+// a followed link is compiled as what it leads to.
+type FileContent struct {
+	MIMEType    string
+	Hash        string
+	QuickHash   string
+	HashPending bool
+	HashError   string
+	Exif        map[string]interface{}
+	Link        *symlink.Link
+}
+
+// DetectType detects a regular file's type from its content, or from its
+// extension if it can't be read; the failure is reported by Hash
+func (ix *Indexer) DetectType(content *FileContent, pathValue string) {
+	mimeType, err := fileType.Detect(pathValue)
+	if err != nil {
+		mimeType = fileType.FromExtension(filepath.Ext(pathValue))
+	}
+	content.MIMEType = mimeType
+}
+
+// Hash hashes a regular file, or only fingerprints it when it is over the
+// quick hash threshold. A file that can't be read is still indexed, so the
+// error is kept for its record and reported rather than returned alone.
+func (ix *Indexer) Hash(content *FileContent, pathValue string, size int64) error {
+	var err error
+	if ix.quickHashThreshold > 0 && size >= ix.quickHashThreshold {
+		content.QuickHash, err = ix.hashAlgo.Fingerprint(pathValue, ix.quickHashSample)
+		content.HashPending = err == nil
+	} else {
+		content.Hash, err = ix.hashAlgo.File(pathValue)
+	}
+	if err != nil {
+		content.HashError = err.Error()
+		ix.ReportError(pathValue, StageHash, err)
+	}
+	return err
+}
+
+// ExtractMetadata reads a regular file's metadata with the extractors
+// configured for it
+func (ix *Indexer) ExtractMetadata(ctx context.Context, pathValue, mimeType string) (map[string]interface{}, error) {
+	return ix.extractors.Extract(ctx, pathValue, mimeType)
+}
+
+// IndexPath indexes a single path on its own: a regular file is typed,
+// hashed and has its metadata read before its record is written. Failures
+// are reported as well as returned.
+func (ix *Indexer) IndexPath(ctx context.Context, pathValue, rootValue string, fileInfo os.FileInfo, totals DirectoryTotals) error {
+	var content FileContent
+	if fileInfo.Mode().IsRegular() {
+		if ix.Incremental {
+			unchanged, err := ix.TouchIfUnchanged(ctx, pathValue, fileInfo)
+			if err != nil {
+				return fmt.Errorf("failed to look up previous index data: %v", err)
+			}
+			if unchanged {
+				return nil
+			}
+		}
+		ix.DetectType(&content, pathValue)
+		ix.Hash(&content, pathValue, fileInfo.Size())
+		var err error
+		content.Exif, err = ix.ExtractMetadata(ctx, pathValue, content.MIMEType)
+		if err != nil {
+			ix.ReportError(pathValue, StageExif, err)
+		}
+	}
+
+	err := ix.CompileAndWrite(ctx, pathValue, rootValue, fileInfo, totals, content)
+	if err != nil {
+		ix.ReportError(pathValue, StageWrite, err)
+	}
+	return err
+}
+
+// CompileAndWrite compiles a record and writes it, its file version and its
+// tree node
+func (ix *Indexer) CompileAndWrite(ctx context.Context, pathValue, rootValue string, fileInfo os.FileInfo, totals DirectoryTotals, content FileContent) error {
+	dataInfo, err := ix.compileData(pathValue, rootValue, fileInfo, totals, content)
+	if err != nil {
+		return err
+	}
+
+	err = ix.sink.Write(ctx, dataInfo)
+	if err != nil {
+		return fmt.Errorf("failed to write data to sink: %v", err)
+	}
+
+	err = recordVersion(ctx, ix.sink, dataInfo, dataInfo.Time("IndexTime"))
+	if err != nil {
+		return fmt.Errorf("failed to record file version: %v", err)
+	}
+
+	err = writeTreeNode(ctx, ix.sink, dataInfo, rootValue)
+	if err != nil {
+		return fmt.Errorf("failed to write tree node: %v", err)
+	}
+
+	return nil
+}
+
+// Compile directory or file data
+func (ix *Indexer) compileData(pathValue, rootValue string, fileInfo os.FileInfo, totals DirectoryTotals, content FileContent) (mongoWrite.Document, error) {
+	if content.Link != nil && !isSymbolicLink(fileInfo) {
+		// A followed link is compiled as what it leads to, then marked
+		link := *content.Link
+		content.Link = nil
+		doc, err := ix.compileData(pathValue, rootValue, fileInfo, totals, content)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := mongoWrite.ToDocument(linkFields(link, true))
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range fields {
+			doc[key] = value
+		}
+		doc["IsSymLink"] = true
+		return doc, nil
+	}
+
+	base := ix.compileBaseRecord(pathValue, rootValue, fileInfo)
+
+	if isSymbolicLink(fileInfo) {
+		// For symlinks, record where the chain of links ends up
+		var link symlink.Link
+		if content.Link != nil {
+			link = *content.Link
+		} else {
+			var err error
+			if link, err = symlink.Resolve(pathValue); err != nil {
+				return nil, err
+			}
+		}
+		base.ID = ix.idStrategy.ForPath(hashPath(ix.pathHashAlgo, pathValue))
+		base.IsSymLink = true
+
+		return mongoWrite.ToDocument(mongoWrite.SymlinkRecord{
+			BaseRecord: base,
+			LinkFields: linkFields(link, false),
+		})
+	} else if fileInfo.IsDir() {
+		// For directories, compile directory data
+		base.ID = ix.idStrategy.ForPath(hashPath(ix.pathHashAlgo, pathValue))
+		return mongoWrite.ToDocument(mongoWrite.DirectoryRecord{
+			BaseRecord:               base,
+			ChildDirectoryCount:      totals.ChildDirs,
+			ChildFileCount:           totals.ChildFiles,
+			ChildSizeRaw:             totals.ChildSize,
+			DescendentDirectoryCount: totals.DescendantDirs,
+			DescendentFileCount:      totals.DescendantFiles,
+			DescendentSizeRaw:        totals.DescendantSize,
+		})
+	} else {
+		// For files, compile file data from the hash and exif already read
+		base.ID = ix.idStrategy.ForFile(hashPath(ix.pathHashAlgo, pathValue), content.Hash)
+
+		return mongoWrite.ToDocument(mongoWrite.FileRecord{
+			BaseRecord:        base,
+			FileHash:          content.Hash,
+			FileHashAlgo:      ix.hashAlgo.String(),
+			FileQuickHash:     content.QuickHash,
+			FileHashPending:   content.HashPending,
+			HashError:         content.HashError,
+			FileTypeExtension: filepath.Ext(fileInfo.Name()),
+			MIMEType:          content.MIMEType,
+			FileCategory:      fileType.Category(content.MIMEType, filepath.Ext(fileInfo.Name())),
+			Exif:              content.Exif,
+		})
+	}
+}
+
+// Compile the fields shared by every kind of record
+func (ix *Indexer) compileBaseRecord(pathValue, rootValue string, fileInfo os.FileInfo) mongoWrite.BaseRecord {
+	now := time.Now()
+	paths := ancestryPaths(pathValue, rootValue)
+
+	return mongoWrite.BaseRecord{
+		SourceFile:         pathValue,
+		DirectoryName:      filepath.Dir(pathValue),
+		FileName:           fileInfo.Name(),
+		FileSizeRaw:        fileInfo.Size(),
+		FileMode:           fileInfo.Mode().String(),
+		FileModTime:        fileInfo.ModTime(),
+		SourcePathHash:     hashPath(ix.pathHashAlgo, pathValue),
+		DirectoryHash:      hashPath(ix.pathHashAlgo, filepath.Dir(pathValue)),
+		AncestryPaths:      paths,
+		AncestryPathHashes: ancestryPathHashes(ix.pathHashAlgo, paths),
+		IndexTime:          now,
+		LastSeenTime:       now,
+		RunID:              ix.runID,
+		IsDirectory:        fileInfo.IsDir(),
+	}
+}
+
+// UTILITY FUNCTIONS
+
+// Read a file's info using lstat
+func readFileInfo(filePath string) (os.FileInfo, error) {
+	fileInfo, err := os.Lstat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo, nil
+}
+
+// Hash a path as SourcePathHash, DirectoryHash and AncestryPathHashes are
+func hashPath(algo fileHash.Algorithm, pathValue string) string {
+	return algo.Sum([]byte(pathValue))
+}
+
+// Identify the ancestry paths
+func ancestryPaths(pathValue, rootValue string) []string {
+	file := pathValue
+	root := rootValue
+
+	// Get the ancestry paths, stopping at the filesystem root if the path
+	// isn't under root at all
+	var paths []string
+	for {
+		parent := filepath.Dir(file)
+		if parent == file {
+			break
+		}
+		file = parent
+		paths = append(paths, file)
+		if file == root {
+			break
+		}
+	}
+	return paths
+}
+
+// Compute ancestry path hashes
+func ancestryPathHashes(algo fileHash.Algorithm, ancestryPaths []string) []string {
+	var hashes []string
+	for _, path := range ancestryPaths {
+		hash := hashPath(algo, path)
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// Function to check if a file info represents a symbolic link
+func isSymbolicLink(fileInfo os.FileInfo) bool {
+	return fileInfo.Mode()&os.ModeSymlink != 0
+}
+
+// The document fields describing a resolved link
+func linkFields(link symlink.Link, followed bool) mongoWrite.LinkFields {
+	return mongoWrite.LinkFields{
+		SymlinkDestination: link.Destination,
+		SymlinkChain:       link.Chain,
+		SymlinkTarget:      link.Target,
+		SymlinkBroken:      link.Broken,
+		SymlinkLoop:        link.Loop,
+		SymlinkFollowed:    followed,
+	}
+}
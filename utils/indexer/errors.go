@@ -0,0 +1,104 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The stages of indexing a path that can fail, as named in the error report
+const (
+	StageStat  = "stat"
+	StageHash  = "hash"
+	StageExif  = "exif"
+	StageWrite = "write"
+)
+
+// errorReportEntry is one line of a run's error report
+type errorReportEntry struct {
+	RunID string    `json:"RunID"`
+	Path  string    `json:"Path"`
+	Stage string    `json:"Stage"`
+	Error string    `json:"Error"`
+	Time  time.Time `json:"Time"`
+}
+
+// errorReport lists every path a run failed on, one JSON line per error.
+// The file is only created when the first error is recorded, and a resumed
+// run appends to the report of the run it continues.
+type errorReport struct {
+	filename string
+	runID    string
+
+	mu      sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	count   int64
+}
+
+// Set up the error report for a run in the given directory
+func newErrorReport(dir, runID string) *errorReport {
+	return &errorReport{
+		filename: filepath.Join(dir, "errors-"+runID+".jsonl"),
+		runID:    runID,
+	}
+}
+
+// Append an error to the report
+func (r *errorReport) Record(pathValue, stage string, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if mkErr := os.MkdirAll(filepath.Dir(r.filename), 0755); mkErr != nil {
+			return fmt.Errorf("failed to create error report directory: %v", mkErr)
+		}
+		f, openErr := os.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if openErr != nil {
+			return fmt.Errorf("failed to create error report: %v", openErr)
+		}
+		r.file = f
+		r.encoder = json.NewEncoder(f)
+	}
+
+	r.count++
+	return r.encoder.Encode(errorReportEntry{
+		RunID: r.runID,
+		Path:  pathValue,
+		Stage: stage,
+		Error: err.Error(),
+		Time:  time.Now(),
+	})
+}
+
+// Count returns how many errors have been recorded
+func (r *errorReport) Count() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.count
+}
+
+// Close the report file, if any error was recorded
+func (r *errorReport) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// ReportError logs an error on a path and adds it to the run's error report
+func (ix *Indexer) ReportError(pathValue, stage string, err error) {
+	log.Printf("Failed to %s %s: %v\n", stage, pathValue, err)
+	if ix.errors == nil {
+		return
+	}
+	if reportErr := ix.errors.Record(pathValue, stage, err); reportErr != nil {
+		log.Printf("Failed to write error report: %v\n", reportErr)
+	}
+}
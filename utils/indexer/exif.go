@@ -0,0 +1,272 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// exifPool keeps up to size exiftool processes running in -stay_open mode,
+// so a file costs one request on a pipe instead of a Perl start-up. Each
+// request is numbered with -executeNUM and its end found by the matching
+// {readyNUM} line. A worker whose process dies, or that is killed when a
+// request is cancelled, is thrown away and a fresh one started in its place.
+// When exiftool isn't installed the pool is disabled and files are indexed
+// without exif data.
+type exifPool struct {
+	command  string
+	disabled bool
+
+	// One slot per worker that may be running a request
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*exifWorker
+	closed bool
+	// Requests under way, which Close waits for
+	active sync.WaitGroup
+}
+
+// errExifPoolClosed is returned for a request made after Close
+var errExifPoolClosed = errors.New("exiftool pool is closed")
+
+// exifWorker is one exiftool process reading arguments from stdin
+type exifWorker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *bufio.Reader
+	seq    int
+}
+
+// Set up a pool of exiftool workers. Workers are started as they are needed.
+func newExifPool(command string, size int) *exifPool {
+	if command == "" {
+		command = "exiftool"
+	}
+	if size <= 0 {
+		size = 1
+	}
+	p := &exifPool{
+		command: command,
+		slots:   make(chan struct{}, size),
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		log.Printf("exiftool is not available (%v); files will be indexed without exif data", err)
+		p.disabled = true
+	}
+	return p
+}
+
+// Read a file's exif data as reported by exiftool
+func (p *exifPool) Read(ctx context.Context, filePath string) (map[string]interface{}, error) {
+	if p.disabled {
+		return nil, nil
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errExifPoolClosed
+	}
+	p.active.Add(1)
+	p.mu.Unlock()
+	defer p.active.Done()
+
+	// Arguments are read one per line, so such a path needs its own process
+	if strings.ContainsAny(filePath, "\r\n") {
+		return readExifDataOnce(ctx, p.command, filePath)
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	// A crashed worker gets one retry on a fresh process
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var w *exifWorker
+		w, err = p.get()
+		if err != nil {
+			return nil, err
+		}
+
+		var stdout, stderr []byte
+		stdout, stderr, err = w.run(ctx, filePath)
+		if err != nil {
+			w.kill()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		p.put(w)
+		return parseExifOutput(stdout, stderr)
+	}
+	return nil, fmt.Errorf("exiftool failed: %v", err)
+}
+
+// Take an idle worker, or start a new one
+func (p *exifPool) get() (*exifWorker, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		w := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return w, nil
+	}
+	p.mu.Unlock()
+	return startExifWorker(p.command)
+}
+
+// Return a healthy worker to the pool
+func (p *exifPool) put(w *exifWorker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		w.stop()
+		return
+	}
+	p.idle = append(p.idle, w)
+}
+
+// Stop every worker once the requests under way have finished. Workers
+// are stopped as those requests hand them back, and the idle ones after.
+func (p *exifPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.active.Wait()
+
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, w := range idle {
+		w.stop()
+	}
+}
+
+// Start an exiftool process reading its arguments from stdin
+func startExifWorker(command string) (*exifWorker, error) {
+	cmd := exec.Command(command, "-stay_open", "True", "-@", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start exiftool: %v", err)
+	}
+	return &exifWorker{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+		stderr: bufio.NewReader(stderr),
+	}, nil
+}
+
+// Run one request. -echo4 writes the ready marker to stderr once the file is
+// done, so both streams can be read up to the end of this request.
+func (w *exifWorker) run(ctx context.Context, filePath string) ([]byte, []byte, error) {
+	w.seq++
+	ready := fmt.Sprintf("{ready%d}", w.seq)
+
+	if _, err := fmt.Fprintf(w.stdin, "-j\n-echo4\n%s\n%s\n-execute%d\n", ready, filePath, w.seq); err != nil {
+		return nil, nil, err
+	}
+
+	type output struct {
+		stdout, stderr []byte
+		err            error
+	}
+	done := make(chan output, 1)
+	go func() {
+		var out output
+		if out.stdout, out.err = readUntilMarker(w.stdout, ready); out.err == nil {
+			out.stderr, out.err = readUntilMarker(w.stderr, ready)
+		}
+		done <- out
+	}()
+
+	select {
+	case out := <-done:
+		return out.stdout, out.stderr, out.err
+	case <-ctx.Done():
+		// The reads end once the worker is killed and its pipes closed,
+		// even if something it started still holds them open
+		w.cmd.Process.Kill()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// Ask the process to exit
+func (w *exifWorker) stop() {
+	fmt.Fprint(w.stdin, "-stay_open\nFalse\n")
+	w.stdin.Close()
+	w.cmd.Wait()
+}
+
+// Kill a process that can't be trusted to answer in step any more
+func (w *exifWorker) kill() {
+	w.cmd.Process.Kill()
+	w.cmd.Wait()
+}
+
+// Read lines up to a line holding only the marker, returning what came before
+func readUntilMarker(r *bufio.Reader, marker string) ([]byte, error) {
+	var buf bytes.Buffer
+	for {
+		line, err := r.ReadBytes('\n')
+		if string(bytes.TrimRight(line, "\r\n")) == marker {
+			return buf.Bytes(), nil
+		}
+		buf.Write(line)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Run exiftool once for a single file
+func readExifDataOnce(ctx context.Context, command, filePath string) (map[string]interface{}, error) {
+	cmd := exec.CommandContext(ctx, command, "-j", filePath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.Output()
+	if err != nil && len(stdout) == 0 {
+		return nil, err
+	}
+	return parseExifOutput(stdout, stderr.Bytes())
+}
+
+// Turn exiftool's JSON output into the file's exif data. Warnings on stderr
+// are ignored as long as exiftool reported something.
+func parseExifOutput(stdout, stderr []byte) (map[string]interface{}, error) {
+	var data []map[string]interface{}
+	if err := json.Unmarshal(stdout, &data); err != nil || len(data) == 0 {
+		if msg := strings.TrimSpace(string(stderr)); msg != "" {
+			return nil, fmt.Errorf("exiftool: %s", msg)
+		}
+		// Handle the case when the file has no EXIF data
+		return nil, nil
+	}
+	return data[0], nil
+}
@@ -0,0 +1,169 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeExiftool answers -stay_open requests the way exiftool does: the JSON
+// for the file and {readyNUM} on stdout, and the -echo4 text on stderr. A
+// file named "crash" kills the process the first time it is asked for, and
+// one named "slow" takes a while.
+const fakeExiftool = `#!/bin/sh
+while IFS= read -r line; do
+	case "$line" in
+	False)
+		exit 0 ;;
+	-execute*)
+		case "$file" in
+		*crash*)
+			if [ ! -e "$file.crashed" ]; then
+				: > "$file.crashed"
+				kill -9 $$
+			fi ;;
+		*slow*)
+			sleep 5 ;;
+		esac
+		printf '[{"SourceFile":"%s","Worker":%d}]\n' "$file" $$
+		printf '{ready%s}\n' "${line#-execute}"
+		printf '%s\n' "$echo" >&2
+		file=""
+		echo="" ;;
+	-j|-echo4|-stay_open)
+		;;
+	*)
+		if [ "$prev" = "-echo4" ]; then
+			echo="$line"
+		else
+			file="$line"
+		fi ;;
+	esac
+	prev="$line"
+done
+`
+
+func newFakeExifPool(t *testing.T, size int) (*exifPool, string) {
+	t.Helper()
+	dir := t.TempDir()
+	command := filepath.Join(dir, "exiftool")
+	if err := os.WriteFile(command, []byte(fakeExiftool), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := newExifPool(command, size)
+	t.Cleanup(p.Close)
+	if p.disabled {
+		t.Fatal("pool disabled with a fake exiftool")
+	}
+	return p, dir
+}
+
+func readExif(t *testing.T, p *exifPool, ctx context.Context, filePath string) map[string]interface{} {
+	t.Helper()
+	data, err := p.Read(ctx, filePath)
+	if err != nil {
+		t.Fatalf("Read(%q): %v", filePath, err)
+	}
+	if data["SourceFile"] != filePath {
+		t.Fatalf("Read(%q): answer for %v", filePath, data["SourceFile"])
+	}
+	return data
+}
+
+// Requests on one worker should each get their own answer, framed by the
+// {readyNUM} marker
+func TestExifPoolReady(t *testing.T) {
+	p, dir := newFakeExifPool(t, 1)
+	ctx := context.Background()
+
+	var worker interface{}
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		data := readExif(t, p, ctx, filepath.Join(dir, name))
+		if worker != nil && data["Worker"] != worker {
+			t.Errorf("%s was read by worker %v, not %v", name, data["Worker"], worker)
+		}
+		worker = data["Worker"]
+	}
+}
+
+// A worker that dies in the middle of a request should be replaced, and the
+// request retried on the new one
+func TestExifPoolCrashedWorker(t *testing.T) {
+	p, dir := newFakeExifPool(t, 1)
+	ctx := context.Background()
+
+	before := readExif(t, p, ctx, filepath.Join(dir, "a.jpg"))
+	readExif(t, p, ctx, filepath.Join(dir, "crash.jpg"))
+	after := readExif(t, p, ctx, filepath.Join(dir, "b.jpg"))
+	if before["Worker"] == after["Worker"] {
+		t.Error("crashed worker is still in use")
+	}
+}
+
+// Cancelling a request should kill its worker, and the next request should
+// get a fresh one
+func TestExifPoolCancel(t *testing.T) {
+	p, dir := newFakeExifPool(t, 1)
+
+	before := readExif(t, p, context.Background(), filepath.Join(dir, "a.jpg"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.Read(ctx, filepath.Join(dir, "slow.jpg")); err != context.DeadlineExceeded {
+		t.Errorf("cancelled request: have error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled request took %s", elapsed)
+	}
+
+	after := readExif(t, p, context.Background(), filepath.Join(dir, "b.jpg"))
+	if before["Worker"] == after["Worker"] {
+		t.Error("killed worker is still in use")
+	}
+}
+
+// Without exiftool files should be indexed without exif data
+func TestExifPoolNotInstalled(t *testing.T) {
+	p := newExifPool(filepath.Join(t.TempDir(), "exiftool"), 1)
+	defer p.Close()
+	if !p.disabled {
+		t.Fatal("pool enabled without exiftool")
+	}
+	data, err := p.Read(context.Background(), "a.jpg")
+	if data != nil || err != nil {
+		t.Errorf("Read: have %v, %v, want nil, nil", data, err)
+	}
+}
+
+// Close should wait for the requests under way, and turn away later ones
+func TestExifPoolCloseWaits(t *testing.T) {
+	p, dir := newFakeExifPool(t, 1)
+	readExif(t, p, context.Background(), filepath.Join(dir, "a.jpg"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	finished := make(chan error, 1)
+	go func() {
+		_, err := p.Read(ctx, filepath.Join(dir, "slow.jpg"))
+		finished <- err
+	}()
+	// Let the request reach its worker
+	time.Sleep(100 * time.Millisecond)
+
+	p.Close()
+	select {
+	case err := <-finished:
+		if err != context.DeadlineExceeded {
+			t.Errorf("request under way: have error %v, want %v", err, context.DeadlineExceeded)
+		}
+	default:
+		t.Error("Close returned before the request under way finished")
+	}
+
+	if _, err := p.Read(context.Background(), filepath.Join(dir, "b.jpg")); err != errExifPoolClosed {
+		t.Errorf("request after Close: have error %v, want %v", err, errExifPoolClosed)
+	}
+}
@@ -0,0 +1,312 @@
+package indexer
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// extractor reads metadata from one kind of file. Native extractors use
+// exiftool's tag names, so their results sit in the same Exif sub-document
+// whichever tool produced them.
+type extractor struct {
+	name    string
+	extract func(ctx context.Context, pathValue string) (map[string]interface{}, error)
+	// Whether it reads all exiftool would find in the files it is registered
+	// for, so exiftool doesn't read them again
+	complete bool
+}
+
+// extractorRegistry decides which extractors run on a file. Extensions in
+// NoExif get none. Native extractors run for the extensions and MIME types
+// they are registered for; exiftool runs on everything else that isn't
+// skipped and that no complete native extractor has read, or only on the
+// ExifAllow extensions when that list is set. Where both report a tag,
+// exiftool's value wins.
+type extractorRegistry struct {
+	byExtension map[string][]extractor
+	byMIMEType  map[string][]extractor
+	skip        map[string]bool
+	exifAllow   map[string]bool
+	exifTool    *exifPool
+}
+
+// Build the registry from the NoExif and ExifAllow lists in conf.json
+func newExtractorRegistry(config Options, exifTool *exifPool) *extractorRegistry {
+	r := &extractorRegistry{
+		byExtension: make(map[string][]extractor),
+		byMIMEType:  make(map[string][]extractor),
+		skip:        extensionSet(config.NoExif),
+		exifAllow:   extensionSet(config.ExifAllow),
+		exifTool:    exifTool,
+	}
+
+	images := extractor{name: "image", extract: extractImageConfig}
+	r.registerMIMEType(images, "image/jpeg", "image/png")
+	// GIFs carry nothing but their dimensions
+	images.complete = true
+	r.registerMIMEType(images, "image/gif")
+
+	pdf := extractor{name: "pdf", extract: extractPDFPageCount}
+	r.registerMIMEType(pdf, "application/pdf")
+
+	archives := extractor{name: "zip", extract: extractZipListing}
+	// Formats that are zip archives underneath; exiftool reads the document
+	// properties of office files and ebooks
+	r.registerExtension(archives, "docx", "xlsx", "pptx", "odt", "ods", "odp", "epub")
+	// Of other archives exiftool only reads what the listing already has
+	archives.complete = true
+	r.registerMIMEType(archives, "application/zip")
+	r.registerExtension(archives, "jar", "apk")
+
+	return r
+}
+
+// Run an extractor on files with any of the extensions
+func (r *extractorRegistry) registerExtension(e extractor, extensions ...string) {
+	for _, ext := range extensions {
+		r.byExtension[ext] = append(r.byExtension[ext], e)
+	}
+}
+
+// Run an extractor on files of any of the MIME types
+func (r *extractorRegistry) registerMIMEType(e extractor, mimeTypes ...string) {
+	for _, mimeType := range mimeTypes {
+		r.byMIMEType[mimeType] = append(r.byMIMEType[mimeType], e)
+	}
+}
+
+// Extract a regular file's metadata, given its detected MIME type. Whatever
+// was read is returned even when some extractor failed; the error describes
+// the failures.
+func (r *extractorRegistry) Extract(ctx context.Context, pathValue, mimeType string) (map[string]interface{}, error) {
+	ext := fileExtension(pathValue)
+	if r.skip[ext] {
+		return nil, nil
+	}
+
+	var data map[string]interface{}
+	var errs []error
+	merge := func(found map[string]interface{}) {
+		if len(found) == 0 {
+			return
+		}
+		if data == nil {
+			data = make(map[string]interface{}, len(found))
+		}
+		for key, value := range found {
+			data[key] = value
+		}
+	}
+
+	handled := false
+	for _, e := range r.forFile(ext, mimeType) {
+		found, err := e.extract(ctx, pathValue)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s extractor: %v", e.name, err))
+		} else if e.complete {
+			handled = true
+		}
+		merge(found)
+	}
+
+	// A file ExifAllow names is read by exiftool whatever else read it
+	if r.exifAllow[ext] || (len(r.exifAllow) == 0 && !handled) {
+		found, err := r.exifTool.Read(ctx, pathValue)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		merge(found)
+	}
+
+	return data, errors.Join(errs...)
+}
+
+// The native extractors for a file, by extension and then by MIME type
+func (r *extractorRegistry) forFile(ext, mimeType string) []extractor {
+	found := append([]extractor(nil), r.byExtension[ext]...)
+	for _, e := range r.byMIMEType[mimeType] {
+		if !containsExtractor(found, e.name) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func containsExtractor(extractors []extractor, name string) bool {
+	for _, e := range extractors {
+		if e.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// A file's extension, lower case and without the dot
+func fileExtension(pathValue string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(pathValue), "."))
+}
+
+// Normalise a list of extensions from conf.json into a set
+func extensionSet(extensions []string) map[string]bool {
+	set := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		set[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	return set
+}
+
+// NATIVE EXTRACTORS
+
+// Read an image's dimensions from its header
+func extractImageConfig(ctx context.Context, pathValue string) (map[string]interface{}, error) {
+	f, err := os.Open(pathValue)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"ImageWidth":  config.Width,
+		"ImageHeight": config.Height,
+		"FileType":    strings.ToUpper(format),
+	}, nil
+}
+
+// PDFs are searched for their page count this far into the file at most
+const maxPDFScanSize = 16 << 20
+
+// PDF objects bigger than this, like content streams and images, are
+// searched in pieces of this size
+const maxPDFObjectSize = 256 << 10
+
+// Each piece of a big object starts this far back in the one before, so a
+// tag split between them is found whole in the second
+const pdfPieceOverlap = 64
+
+var pdfPagesType = regexp.MustCompile(`/Type\s*/Pages\b`)
+var pdfPageType = regexp.MustCompile(`/Type\s*/Page\b`)
+var pdfCount = regexp.MustCompile(`/Count\s+(\d+)`)
+var pdfEndObj = []byte("endobj")
+
+// Count a PDF's pages. The root page tree node holds the total, and it is
+// the largest /Count of any page tree node. PDFs that keep their page tree
+// in compressed object streams fall back to counting page objects, and
+// report nothing if those are compressed too. The file is read one object
+// at a time, so only one object is ever held in memory.
+func extractPDFPageCount(ctx context.Context, pathValue string) (map[string]interface{}, error) {
+	f, err := os.Open(pathValue)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(f, header); err != nil || string(header) != "%PDF-" {
+		return nil, errors.New("not a PDF file")
+	}
+
+	var split pdfObjectSplitter
+	scanner := bufio.NewScanner(io.LimitReader(f, maxPDFScanSize))
+	scanner.Buffer(make([]byte, 0, 64<<10), maxPDFObjectSize)
+	scanner.Split(split.split)
+
+	pages, pageObjects := 0, 0
+	for scanner.Scan() {
+		object := scanner.Bytes()
+		// A page is counted in the piece it ends in, not in the overlap
+		// that is searched again
+		for _, match := range pdfPageType.FindAllIndex(object, -1) {
+			if match[1] <= split.owned {
+				pageObjects++
+			}
+		}
+		if !pdfPagesType.Match(object) {
+			continue
+		}
+		for _, match := range pdfCount.FindAllSubmatch(object, -1) {
+			if count, err := strconv.Atoi(string(match[1])); err == nil && count > pages {
+				pages = count
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if pages == 0 {
+		pages = pageObjects
+	}
+	if pages == 0 {
+		return nil, nil
+	}
+	return map[string]interface{}{"PageCount": pages}, nil
+}
+
+// pdfObjectSplitter splits a PDF into its objects, each ending with
+// "endobj". An object bigger than maxPDFObjectSize is passed on in pieces
+// that overlap by pdfPieceOverlap bytes.
+type pdfObjectSplitter struct {
+	// How much of the last token is its own rather than the start of the
+	// next one
+	owned int
+}
+
+func (s *pdfObjectSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.Index(data, pdfEndObj); i >= 0 {
+		end := i + len(pdfEndObj)
+		s.owned = end
+		return end, data[:end], nil
+	}
+	if len(data) >= maxPDFObjectSize {
+		s.owned = len(data) - pdfPieceOverlap
+		return s.owned, data, nil
+	}
+	if atEOF && len(data) > 0 {
+		s.owned = len(data)
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// At most this many entry names are kept for a zip archive
+const maxZipEntries = 1000
+
+// List a zip archive's entries
+func extractZipListing(ctx context.Context, pathValue string) (map[string]interface{}, error) {
+	archive, err := zip.OpenReader(pathValue)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	var names []string
+	var size int64
+	for _, entry := range archive.File {
+		if len(names) < maxZipEntries {
+			names = append(names, entry.Name)
+		}
+		size += int64(entry.UncompressedSize64)
+	}
+	return map[string]interface{}{
+		"ZipEntryCount":       len(archive.File),
+		"ZipEntries":          names,
+		"ZipUncompressedSize": size,
+	}, nil
+}
@@ -0,0 +1,144 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractPDFPageCount(t *testing.T) {
+	// An image stream bigger than an object the scanner holds at once
+	image := "4 0 obj\n<< /Length 1048576 >>\nstream\n" + strings.Repeat("x", 1<<20) + "\nendstream\nendobj\n"
+	// A page object too big to be searched whole, with its /Type /Page at
+	// offset in what is read after "%PDF-"
+	bigPage := func(offset int) string {
+		start := "1.4\n3 0 obj\n<< "
+		return "%PDF-" + start + strings.Repeat(" ", offset-len(start)) + "/Type /Page >>\n" +
+			strings.Repeat("x", maxPDFObjectSize) + "\nendobj\n"
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    interface{}
+	}{
+		{
+			name: "page tree",
+			content: "%PDF-1.4\n" +
+				"1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n" +
+				"2 0 obj\n<< /Type /Pages /Kids [3 0 R 5 0 R 6 0 R] /Count 3 >>\nendobj\n" +
+				image +
+				"3 0 obj\n<< /Type /Page /Parent 2 0 R >>\nendobj\n",
+			want: 3,
+		},
+		{
+			name: "page objects only",
+			content: "%PDF-1.4\n" +
+				"3 0 obj\n<< /Type /Page >>\nendobj\n" +
+				image +
+				"5 0 obj\n<< /Type /Page >>\nendobj\n",
+			want: 2,
+		},
+		{
+			name:    "page in the first piece of an object",
+			content: bigPage(100),
+			want:    1,
+		},
+		{
+			name:    "page split between pieces of an object",
+			content: bigPage(maxPDFObjectSize - 5),
+			want:    1,
+		},
+		{
+			name:    "page in the overlap of pieces of an object",
+			content: bigPage(maxPDFObjectSize - 40),
+			want:    1,
+		},
+		{
+			name:    "no pages",
+			content: "%PDF-1.4\n" + image,
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pathValue := filepath.Join(t.TempDir(), "test.pdf")
+			if err := os.WriteFile(pathValue, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			meta, err := extractPDFPageCount(context.Background(), pathValue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := meta["PageCount"]; have != tt.want {
+				t.Errorf("PageCount: have %v, want %v", have, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractPDFPageCountNotPDF(t *testing.T) {
+	pathValue := filepath.Join(t.TempDir(), "test.pdf")
+	if err := os.WriteFile(pathValue, bytes.Repeat([]byte("no"), 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractPDFPageCount(context.Background(), pathValue); err == nil {
+		t.Error("no error for a file that isn't a PDF")
+	}
+}
+
+// Exiftool shouldn't read a file again after a native extractor that reads
+// all it would has, unless ExifAllow names it
+func TestExtractSkipsExiftool(t *testing.T) {
+	pool, dir := newFakeExifPool(t, 1)
+	write := func(name string, encode func(io.Writer, image.Image) error) string {
+		t.Helper()
+		pathValue := filepath.Join(dir, name)
+		f, err := os.Create(pathValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := encode(f, image.NewPaletted(image.Rect(0, 0, 2, 1), color.Palette{color.Black})); err != nil {
+			t.Fatal(err)
+		}
+		return pathValue
+	}
+	gifPath := write("test.gif", func(w io.Writer, m image.Image) error { return gif.Encode(w, m, nil) })
+	pngPath := write("test.png", png.Encode)
+
+	tests := []struct {
+		name      string
+		exifAllow []string
+		pathValue string
+		mimeType  string
+		exiftool  bool
+	}{
+		{"gif", nil, gifPath, "image/gif", false},
+		{"png", nil, pngPath, "image/png", true},
+		{"gif in ExifAllow", []string{"gif"}, gifPath, "image/gif", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newExtractorRegistry(Options{ExifAllow: tt.exifAllow}, pool)
+			data, err := r.Extract(context.Background(), tt.pathValue, tt.mimeType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data["ImageWidth"] != 2 {
+				t.Errorf("ImageWidth: have %v, want 2", data["ImageWidth"])
+			}
+			if _, ok := data["Worker"]; ok != tt.exiftool {
+				t.Errorf("read by exiftool: have %v, want %v", ok, tt.exiftool)
+			}
+		})
+	}
+}
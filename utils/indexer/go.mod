@@ -0,0 +1,41 @@
+module RSKGroup/OPIe/utils/indexer
+
+go 1.20
+
+require go.mongodb.org/mongo-driver v1.12.0
+
+require RSKGroup/OPIe/utils/documentId v0.0.0
+
+replace RSKGroup/OPIe/utils/documentId => ../documentId
+
+require RSKGroup/OPIe/utils/mongoWrite v0.0.0
+
+replace RSKGroup/OPIe/utils/mongoWrite => ../mongoWrite
+
+require RSKGroup/OPIe/utils/fileHash v0.0.0
+
+replace RSKGroup/OPIe/utils/fileHash => ../fileHash
+
+require RSKGroup/OPIe/utils/fileType v0.0.0
+
+replace RSKGroup/OPIe/utils/fileType => ../fileType
+
+require RSKGroup/OPIe/utils/symlink v0.0.0
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/golang/snappy v0.0.1 // indirect
+	github.com/klauspost/compress v1.13.6 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/scram v1.1.2 // indirect
+	github.com/xdg-go/stringprep v1.0.4 // indirect
+	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
+	github.com/zeebo/blake3 v0.2.3 // indirect
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
+	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4 // indirect
+	golang.org/x/text v0.7.0 // indirect
+)
+
+replace RSKGroup/OPIe/utils/symlink => ../symlink
@@ -0,0 +1,156 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"RSKGroup/OPIe/utils/documentId"
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexLookup is implemented by sinks that can return the most recently
+// indexed document for a path, keyed by its SourcePathHash. Lookup returns
+// nil, nil when the path has never been indexed.
+type IndexLookup interface {
+	Lookup(ctx context.Context, pathHash string) (mongoWrite.Document, error)
+}
+
+// TouchIfUnchanged checks the sink for an unchanged copy of a regular file.
+// When the stored size and modification time still match, only the
+// last-seen marker is written and true is returned so the caller can skip
+// hashing and exif.
+func (ix *Indexer) TouchIfUnchanged(ctx context.Context, pathValue string, fileInfo os.FileInfo) (bool, error) {
+	sink := ix.sink
+	lookup, ok := sink.(IndexLookup)
+	if !ok || !fileInfo.Mode().IsRegular() {
+		return false, nil
+	}
+
+	stored, err := lookup.Lookup(ctx, hashPath(ix.pathHashAlgo, pathValue))
+	if err != nil || stored == nil {
+		return false, err
+	}
+	// A file that failed to hash last time is always retried, and one hashed
+	// with a different algorithm is hashed again
+	if hashError, _ := stored["HashError"].(string); hashError != "" {
+		return false, nil
+	}
+	if storedHashAlgo(stored) != ix.hashAlgo.String() {
+		return false, nil
+	}
+	// Documents from before content type detection need their type filled in
+	if _, ok := stored["MIMEType"]; !ok {
+		return false, nil
+	}
+	// BSON dates only keep milliseconds
+	if stored.Int64("FileSizeRaw") != fileInfo.Size() ||
+		!stored.Time("FileModTime").Equal(fileInfo.ModTime().Truncate(time.Millisecond)) {
+		return false, nil
+	}
+
+	now := time.Now()
+	err = sink.Write(ctx, mongoWrite.Document{
+		"_id":          stored.ID(),
+		"LastSeenTime": now,
+		"RunID":        ix.runID,
+		"Deleted":      false,
+	})
+	if err != nil {
+		return true, err
+	}
+	return true, recordVersion(ctx, sink, stored, now)
+}
+
+// RefreshMode brings the stored FileMode of a path whose permissions or
+// ownership changed up to date. When the sink has no live copy of the path,
+// or its size or modification time changed too, nothing is written and
+// false is returned so the caller can index it in full.
+func (ix *Indexer) RefreshMode(ctx context.Context, pathValue string, fileInfo os.FileInfo) (bool, error) {
+	sink := ix.sink
+	lookup, ok := sink.(IndexLookup)
+	if !ok {
+		return false, nil
+	}
+
+	stored, err := lookup.Lookup(ctx, hashPath(ix.pathHashAlgo, pathValue))
+	if err != nil || stored == nil || stored.Bool("Deleted") {
+		return false, err
+	}
+	if stored.Int64("FileSizeRaw") != fileInfo.Size() ||
+		!stored.Time("FileModTime").Equal(fileInfo.ModTime().Truncate(time.Millisecond)) {
+		return false, nil
+	}
+
+	err = sink.Write(ctx, mongoWrite.Document{
+		"_id":          stored.ID(),
+		"FileMode":     fileInfo.Mode().String(),
+		"LastSeenTime": time.Now(),
+		"RunID":        ix.runID,
+	})
+	return err == nil, err
+}
+
+func (s *mongoSink) Lookup(ctx context.Context, pathHash string) (mongoWrite.Document, error) {
+	if s.ids == documentId.PathIdentity {
+		// A path's document is keyed by its path hash, unless the collection
+		// still holds ids of another strategy
+		doc, err := lookupIDInDB(ctx, s.collection, pathHash)
+		if doc != nil || err != nil {
+			return doc, err
+		}
+	}
+	return lookupDataInDB(ctx, s.collection, pathHash)
+}
+
+func (s *memorySink) Lookup(ctx context.Context, pathHash string) (mongoWrite.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest mongoWrite.Document
+	for _, doc := range s.docs {
+		if doc["SourcePathHash"] == pathHash && (latest == nil || doc.Time("IndexTime").After(latest.Time("IndexTime"))) {
+			latest = doc
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	found := make(mongoWrite.Document, len(latest))
+	for key, value := range latest {
+		found[key] = value
+	}
+	return found, nil
+}
+
+// Find a document by _id in MongoDB
+func lookupIDInDB(ctx context.Context, collection *mongo.Collection, id string) (mongoWrite.Document, error) {
+	var doc mongoWrite.Document
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Find the most recently indexed document for a path hash in MongoDB
+func lookupDataInDB(ctx context.Context, collection *mongo.Collection, pathHash string) (mongoWrite.Document, error) {
+	filter := bson.M{"SourcePathHash": pathHash}
+	opts := options.FindOne().SetSort(bson.D{{Key: "IndexTime", Value: -1}})
+
+	var doc mongoWrite.Document
+	err := collection.FindOne(ctx, filter, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
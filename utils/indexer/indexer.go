@@ -0,0 +1,197 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"RSKGroup/OPIe/utils/documentId"
+	"RSKGroup/OPIe/utils/fileHash"
+)
+
+// Options are the indexing settings read from conf.json. Programs embed it
+// in their own configuration, so the keys are the same everywhere.
+type Options struct {
+	DbType       string `json:"DbType"`
+	Host         string `json:"Host"`
+	Port         string `json:"Port"`
+	DbUser       string `json:"DbUser"`
+	DbPwd        string `json:"DbPwd"`
+	DbName       string `json:"DbName"`
+	FileColl     string `json:"FileColl"`
+	TreeColl     string `json:"TreeColl"`
+	VersionColl  string `json:"VersionColl"`
+	Sink         string `json:"Sink"`
+	SinkPath     string `json:"SinkPath"`
+	BatchSize    int    `json:"BatchSize"`
+	FlushSeconds int    `json:"FlushSeconds"`
+	IdStrategy   string `json:"IdStrategy"`
+	HashAlgo     string `json:"HashAlgo"`
+	// Algorithm of SourcePathHash and the other path hashes ids are built
+	// from; sha1 when unset
+	PathHashAlgo string `json:"PathHashAlgo"`
+	// Files at least this big only get a quick fingerprint when indexed,
+	// sampling QuickHashSampleMiB at their start, middle and end; 0 disables it
+	QuickHashThresholdMiB int64    `json:"QuickHashThresholdMiB"`
+	QuickHashSampleMiB    int64    `json:"QuickHashSampleMiB"`
+	ExiftoolPath          string   `json:"ExiftoolPath"`
+	ExifWorkers           int      `json:"ExifWorkers"`
+	NoExif                []string `json:"NoExif"`
+	ExifAllow             []string `json:"ExifAllow"`
+	ErrorReportDir        string   `json:"ErrorReportDir"`
+}
+
+// Indexer compiles index documents for files and directories and writes them
+// to its sink. It owns the sink's database connection and the pool of
+// exiftool processes, so one Indexer is shared by everything a program
+// indexes, from as many goroutines as it likes.
+type Indexer struct {
+	// Skip hashing and exif for regular files whose stored size and
+	// modification time are unchanged
+	Incremental bool
+
+	sink               IndexSink
+	idStrategy         documentId.Strategy
+	hashAlgo           fileHash.Algorithm
+	pathHashAlgo       fileHash.Algorithm
+	quickHashThreshold int64
+	quickHashSample    int64
+	exifTool           *exifPool
+	extractors         *extractorRegistry
+	errorReportDir     string
+
+	runID  string
+	errors *errorReport
+	// Files and directories whose queued write failed
+	failedFiles       int64
+	failedDirectories int64
+	// Held while the directories above a change are recounted
+	recountMu sync.Mutex
+}
+
+// New opens the configured sink and sets up metadata extraction
+func New(opts Options) (*Indexer, error) {
+	idStrategy, err := documentId.ParseStrategy(opts.IdStrategy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	hashAlgo, err := fileHash.ParseAlgorithm(opts.HashAlgo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	pathHashAlgo, err := fileHash.ParseAlgorithm(opts.PathHashAlgo)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	sink, err := newIndexSink(opts, idStrategy, pathHashAlgo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index sink: %v", err)
+	}
+
+	ix := &Indexer{
+		sink:               sink,
+		idStrategy:         idStrategy,
+		hashAlgo:           hashAlgo,
+		pathHashAlgo:       pathHashAlgo,
+		quickHashThreshold: opts.QuickHashThresholdMiB << 20,
+		quickHashSample:    opts.QuickHashSampleMiB << 20,
+		errorReportDir:     opts.ErrorReportDir,
+	}
+	if ix.quickHashSample <= 0 {
+		ix.quickHashSample = 4 << 20
+	}
+	if s, ok := sink.(*mongoSink); ok {
+		s.reportFailuresTo(ix)
+	}
+	// Say so when the sink can't keep what the configuration asks for,
+	// rather than silently doing without it
+	if _, ok := sink.(VersionRecorder); !ok && opts.VersionColl != "" {
+		log.Printf("Sink %q doesn't record file versions; VersionColl is ignored", opts.Sink)
+	}
+	if _, ok := sink.(TreeWriter); !ok && opts.TreeColl != "" {
+		log.Printf("Sink %q doesn't write tree nodes; TreeColl is ignored", opts.Sink)
+	}
+	ix.exifTool = newExifPool(opts.ExiftoolPath, opts.ExifWorkers)
+	ix.extractors = newExtractorRegistry(opts, ix.exifTool)
+	return ix, nil
+}
+
+// StartRun stamps every document written from now on with runID, and starts
+// the run's error report when ErrorReportDir is set. A resumed run passes
+// the ID of the run it continues, and appends to its report.
+func (ix *Indexer) StartRun(runID string) {
+	ix.runID = runID
+	if ix.errors != nil {
+		ix.errors.Close()
+		ix.errors = nil
+	}
+	if ix.errorReportDir != "" {
+		ix.errors = newErrorReport(ix.errorReportDir, runID)
+	}
+}
+
+// RunID returns the ID of the current run
+func (ix *Indexer) RunID() string {
+	return ix.runID
+}
+
+// Sink returns the sink documents are written to, for the optional
+// interfaces it may implement
+func (ix *Indexer) Sink() IndexSink {
+	return ix.sink
+}
+
+// IDStrategy returns the configured document id strategy
+func (ix *Indexer) IDStrategy() documentId.Strategy {
+	return ix.idStrategy
+}
+
+// HashAlgo returns the configured content hash algorithm
+func (ix *Indexer) HashAlgo() fileHash.Algorithm {
+	return ix.hashAlgo
+}
+
+// PathHashAlgo returns the configured path hash algorithm
+func (ix *Indexer) PathHashAlgo() fileHash.Algorithm {
+	return ix.pathHashAlgo
+}
+
+// ErrorReport returns the current run's error report file and how many
+// errors were written to it; the name is empty when there is no report
+func (ix *Indexer) ErrorReport() (string, int64) {
+	if ix.errors == nil {
+		return "", 0
+	}
+	return ix.errors.filename, ix.errors.Count()
+}
+
+// WriteFailures returns how many files and directories the sink accepted and
+// then failed to write, as a batch did. Flush the sink first to count them
+// all.
+func (ix *Indexer) WriteFailures() (files, directories int64) {
+	return atomic.LoadInt64(&ix.failedFiles), atomic.LoadInt64(&ix.failedDirectories)
+}
+
+// writeFailed reports a document the sink failed to write after accepting it
+func (ix *Indexer) writeFailed(write queuedWrite, err error) {
+	if write.IsDirectory {
+		atomic.AddInt64(&ix.failedDirectories, 1)
+	} else {
+		atomic.AddInt64(&ix.failedFiles, 1)
+	}
+	ix.ReportError(write.Path, StageWrite, err)
+}
+
+// Close stops the exiftool processes, flushes and closes the sink and
+// closes the error report
+func (ix *Indexer) Close() error {
+	ix.exifTool.Close()
+	err := ix.sink.Close()
+	if ix.errors != nil {
+		ix.errors.Close()
+	}
+	return err
+}
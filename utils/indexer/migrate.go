@@ -0,0 +1,172 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"RSKGroup/OPIe/utils/documentId"
+	"RSKGroup/OPIe/utils/fileHash"
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexMigrator is implemented by sinks whose stored documents can be
+// rewritten to a different id strategy, or to path hashes made with a
+// different PathHashAlgo. Migrate returns how many documents were rewritten.
+type IndexMigrator interface {
+	Migrate(ctx context.Context, strategy documentId.Strategy) (int64, error)
+}
+
+func (s *mongoSink) Migrate(ctx context.Context, strategy documentId.Strategy) (int64, error) {
+	for _, bulk := range []*bulkWriter{s.bulk, s.versionBulk, s.treeBulk} {
+		if bulk != nil {
+			bulk.writePending()
+		}
+	}
+	migrated, err := migrateIdsInDB(ctx, s.collection, strategy, s.pathHashAlgo)
+	if err != nil {
+		return migrated, err
+	}
+	if s.versions != nil {
+		if err := migrateVersionsInDB(ctx, s.versions, s.pathHashAlgo); err != nil {
+			return migrated, err
+		}
+	}
+	if s.tree != nil {
+		if err := migrateTreeInDB(ctx, s.tree, s.pathHashAlgo); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// Rewrite every document in the collection to the id the strategy gives it,
+// and to path hashes made with the algorithm. Documents are visited oldest
+// first, so when several old documents collapse onto one new id (e.g.
+// content versions under the path strategy, or the time-stamped directory
+// ids of older builders) the newest one wins.
+func migrateIdsInDB(ctx context.Context, collection *mongo.Collection, strategy documentId.Strategy, pathHash fileHash.Algorithm) (int64, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "IndexTime", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var migrated int64
+	for cursor.Next(ctx) {
+		var doc mongoWrite.Document
+		if err := cursor.Decode(&doc); err != nil {
+			return migrated, err
+		}
+
+		rehashed := rehashPathFields(doc, pathHash)
+		sourcePathHash, _ := doc["SourcePathHash"].(string)
+		fileHash, _ := doc["FileHash"].(string)
+		regular := !doc.Bool("IsDirectory") && !doc.Bool("IsSymLink")
+		oldID := doc["_id"]
+		newID := strategy.ForDocument(sourcePathHash, fileHash, regular)
+		if oldID == newID && !rehashed {
+			continue
+		}
+
+		doc["_id"] = newID
+		replaceOpts := options.Replace().SetUpsert(true)
+		if _, err := collection.ReplaceOne(ctx, bson.M{"_id": newID}, doc, replaceOpts); err != nil {
+			return migrated, fmt.Errorf("failed to write %s: %v", newID, err)
+		}
+		if oldID != newID {
+			if _, err := collection.DeleteOne(ctx, bson.M{"_id": oldID}); err != nil {
+				return migrated, fmt.Errorf("failed to remove %v: %v", oldID, err)
+			}
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
+
+// Rewrite a document's path hashes with the algorithm, reporting whether
+// they were made with a different one
+func rehashPathFields(doc mongoWrite.Document, algo fileHash.Algorithm) bool {
+	sourceFile, _ := doc["SourceFile"].(string)
+	sourcePathHash := hashPath(algo, sourceFile)
+	if doc["SourcePathHash"] == sourcePathHash {
+		return false
+	}
+	doc["SourcePathHash"] = sourcePathHash
+	doc["DirectoryHash"] = hashPath(algo, filepath.Dir(sourceFile))
+	if paths, ok := doc["AncestryPaths"].(bson.A); ok {
+		hashes := make(bson.A, 0, len(paths))
+		for _, path := range paths {
+			pathValue, _ := path.(string)
+			hashes = append(hashes, hashPath(algo, pathValue))
+		}
+		doc["AncestryPathHashes"] = hashes
+	}
+	return true
+}
+
+// Re-key every file version with path hashes made with the algorithm
+func migrateVersionsInDB(ctx context.Context, collection *mongo.Collection, algo fileHash.Algorithm) error {
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var version mongoWrite.FileVersionRecord
+		if err := cursor.Decode(&version); err != nil {
+			return err
+		}
+		sourcePathHash := hashPath(algo, version.SourceFile)
+		if version.SourcePathHash == sourcePathHash {
+			continue
+		}
+		oldID := version.ID
+		version.SourcePathHash = sourcePathHash
+		version.ID = sourcePathHash + ":" + version.FileHash
+		if err := replaceVersionInDB(ctx, collection, oldID, version); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Re-key every tree node with path hashes made with the algorithm. A node
+// keeps as many ancestors as it had, as they stop at the root it was
+// written under.
+func migrateTreeInDB(ctx context.Context, collection *mongo.Collection, algo fileHash.Algorithm) error {
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var node mongoWrite.TreeNodeRecord
+		if err := cursor.Decode(&node); err != nil {
+			return err
+		}
+		pathHash := hashPath(algo, node.SourceFile)
+		if node.ID == pathHash {
+			continue
+		}
+		oldID := node.ID
+		paths := ancestryPaths(node.SourceFile, "")
+		if len(paths) > len(node.AncestryPathHashes) {
+			paths = paths[:len(node.AncestryPathHashes)]
+		}
+		node.ID = pathHash
+		node.ParentHash = hashPath(algo, filepath.Dir(node.SourceFile))
+		node.AncestryPathHashes = ancestryPathHashes(algo, paths)
+		if err := replaceTreeNodeInDB(ctx, collection, oldID, node); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
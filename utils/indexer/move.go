@@ -0,0 +1,330 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"RSKGroup/OPIe/utils/documentId"
+	"RSKGroup/OPIe/utils/fileHash"
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotSupported is returned for an operation the configured sink can't do
+var ErrNotSupported = errors.New("not supported by the sink")
+
+// IndexMover is implemented by sinks that can move the documents for a
+// path, and for everything below it, to the path it was renamed to, along
+// with their tree nodes and file versions. Move returns how many documents
+// were moved.
+type IndexMover interface {
+	Move(ctx context.Context, move Relocation) (int64, error)
+}
+
+// Relocation describes a renamed path, and rewrites the documents and tree
+// nodes under its old path to the new one
+type Relocation struct {
+	OldPath string
+	NewPath string
+	// Root the new ancestry paths are recorded up to
+	Root     string
+	IDs      documentId.Strategy
+	PathHash fileHash.Algorithm
+	RunID    string
+}
+
+// Move moves the documents for a renamed path, and for everything below it,
+// to the new path. Everything else about them is kept, so a moved file isn't
+// hashed or read again. Documents already at the new path are tombstoned, as
+// the rename replaced what was there. A sink that can't move documents
+// returns ErrNotSupported.
+func (ix *Indexer) Move(ctx context.Context, oldPath, newPath, rootValue string) (int64, error) {
+	mover, ok := ix.sink.(IndexMover)
+	if !ok {
+		return 0, fmt.Errorf("sink %T can't move documents: %w", ix.sink, ErrNotSupported)
+	}
+	return mover.Move(ctx, Relocation{
+		OldPath:  filepath.Clean(oldPath),
+		NewPath:  filepath.Clean(newPath),
+		Root:     rootValue,
+		IDs:      ix.idStrategy,
+		PathHash: ix.pathHashAlgo,
+		RunID:    ix.runID,
+	})
+}
+
+// Path returns where a path under the old path is now
+func (r Relocation) Path(pathValue string) string {
+	return r.NewPath + strings.TrimPrefix(pathValue, r.OldPath)
+}
+
+// Document returns a copy of a document with its path fields and id
+// rewritten for where it is now
+func (r Relocation) Document(doc mongoWrite.Document) mongoWrite.Document {
+	sourceFile, _ := doc["SourceFile"].(string)
+	newPath := r.Path(sourceFile)
+	paths := ancestryPaths(newPath, r.Root)
+	pathHash := hashPath(r.PathHash, newPath)
+
+	moved := make(mongoWrite.Document, len(doc))
+	for key, value := range doc {
+		moved[key] = value
+	}
+	moved["SourceFile"] = newPath
+	moved["DirectoryName"] = filepath.Dir(newPath)
+	moved["FileName"] = filepath.Base(newPath)
+	moved["SourcePathHash"] = pathHash
+	moved["DirectoryHash"] = hashPath(r.PathHash, filepath.Dir(newPath))
+	moved["AncestryPaths"] = paths
+	moved["AncestryPathHashes"] = ancestryPathHashes(r.PathHash, paths)
+	moved["LastSeenTime"] = time.Now()
+	moved["RunID"] = r.RunID
+	moved["Deleted"] = false
+
+	if doc.Bool("IsDirectory") || doc.Bool("IsSymLink") {
+		moved["_id"] = r.IDs.ForPath(pathHash)
+	} else {
+		fileHash, _ := doc["FileHash"].(string)
+		moved["_id"] = r.IDs.ForFile(pathHash, fileHash)
+	}
+	return moved
+}
+
+// Version returns a file version keyed and tagged with where its file is now
+func (r Relocation) Version(version mongoWrite.FileVersionRecord) mongoWrite.FileVersionRecord {
+	version.SourceFile = r.Path(version.SourceFile)
+	version.SourcePathHash = hashPath(r.PathHash, version.SourceFile)
+	version.ID = version.SourcePathHash + ":" + version.FileHash
+	return version
+}
+
+// TreeNode returns a tree node rewritten for where its directory is now
+func (r Relocation) TreeNode(node mongoWrite.TreeNodeRecord) mongoWrite.TreeNodeRecord {
+	newPath := r.Path(node.SourceFile)
+	node.ID = hashPath(r.PathHash, newPath)
+	node.SourceFile = newPath
+	node.FileName = filepath.Base(newPath)
+	node.ParentHash = hashPath(r.PathHash, filepath.Dir(newPath))
+	node.AncestryPathHashes = ancestryPathHashes(r.PathHash, ancestryPaths(newPath, r.Root))
+	node.Depth = treeDepth(newPath, r.Root)
+	node.RunID = r.RunID
+	return node
+}
+
+func (s *mongoSink) Move(ctx context.Context, move Relocation) (int64, error) {
+	if s.bulk != nil {
+		// Queued upserts must land before the documents are read back
+		s.bulk.writePending()
+	}
+	if s.tree != nil {
+		if s.treeBulk != nil {
+			s.treeBulk.writePending()
+		}
+		if err := moveTreeInDB(ctx, s.tree, move); err != nil {
+			return 0, err
+		}
+	}
+	if s.versions != nil {
+		if s.versionBulk != nil {
+			s.versionBulk.writePending()
+		}
+		if err := moveVersionsInDB(ctx, s.versions, move); err != nil {
+			return 0, err
+		}
+	}
+	return moveInDB(ctx, s.collection, move)
+}
+
+// Rewrite the live MongoDB documents under the old path to the new one
+func moveInDB(ctx context.Context, collection *mongo.Collection, move Relocation) (int64, error) {
+	if _, err := tombstoneInDB(ctx, collection, underPathFilter(move.NewPath), false); err != nil {
+		return 0, err
+	}
+
+	filter := underPathFilter(move.OldPath)
+	filter["Deleted"] = bson.M{"$ne": true}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var moved int64
+	for cursor.Next(ctx) {
+		var doc mongoWrite.Document
+		if err := cursor.Decode(&doc); err != nil {
+			return moved, err
+		}
+
+		oldID := doc.ID()
+		newDoc := move.Document(doc)
+		replaceOpts := options.Replace().SetUpsert(true)
+		if _, err := collection.ReplaceOne(ctx, bson.M{"_id": newDoc.ID()}, newDoc, replaceOpts); err != nil {
+			return moved, fmt.Errorf("failed to write %s: %v", newDoc.ID(), err)
+		}
+		if oldID != newDoc.ID() {
+			if _, err := collection.DeleteOne(ctx, bson.M{"_id": oldID}); err != nil {
+				return moved, fmt.Errorf("failed to remove %v: %v", oldID, err)
+			}
+		}
+		moved++
+	}
+	return moved, cursor.Err()
+}
+
+// Rewrite the MongoDB tree nodes under the old path to the new one
+func moveTreeInDB(ctx context.Context, collection *mongo.Collection, move Relocation) error {
+	if _, err := collection.DeleteMany(ctx, underPathFilter(move.NewPath)); err != nil {
+		return err
+	}
+
+	cursor, err := collection.Find(ctx, underPathFilter(move.OldPath))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var node mongoWrite.TreeNodeRecord
+		if err := cursor.Decode(&node); err != nil {
+			return err
+		}
+		if err := replaceTreeNodeInDB(ctx, collection, node.ID, move.TreeNode(node)); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Write a tree node under its new _id and remove it from its old one
+func replaceTreeNodeInDB(ctx context.Context, collection *mongo.Collection, oldID string, node mongoWrite.TreeNodeRecord) error {
+	doc, err := mongoWrite.ToDocument(node)
+	if err != nil {
+		return err
+	}
+	replaceOpts := options.Replace().SetUpsert(true)
+	if _, err := collection.ReplaceOne(ctx, bson.M{"_id": doc.ID()}, doc, replaceOpts); err != nil {
+		return err
+	}
+	if oldID == node.ID {
+		return nil
+	}
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": oldID}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Rewrite the MongoDB file versions under the old path to the new one. The
+// versions already at the new path are the history of what the rename
+// replaced, and are kept; one with the same content is merged into.
+func moveVersionsInDB(ctx context.Context, collection *mongo.Collection, move Relocation) error {
+	cursor, err := collection.Find(ctx, underPathFilter(move.OldPath))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var version mongoWrite.FileVersionRecord
+		if err := cursor.Decode(&version); err != nil {
+			return err
+		}
+		oldID := version.ID
+		if err := replaceVersionInDB(ctx, collection, oldID, move.Version(version)); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Write a file version under its new _id, merging it into one already there,
+// and remove it from its old one
+func replaceVersionInDB(ctx context.Context, collection *mongo.Collection, oldID string, version mongoWrite.FileVersionRecord) error {
+	update := bson.M{
+		"$set": bson.M{
+			"SourceFile":     version.SourceFile,
+			"SourcePathHash": version.SourcePathHash,
+			"FileHash":       version.FileHash,
+			"FileHashAlgo":   version.FileHashAlgo,
+			"FileSizeRaw":    version.FileSizeRaw,
+			"FileModTime":    version.FileModTime,
+		},
+		"$min": bson.M{"FirstSeenTime": version.FirstSeenTime},
+		"$max": bson.M{"LastSeenTime": version.LastSeenTime},
+	}
+	updateOpts := options.Update().SetUpsert(true)
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": version.ID}, update, updateOpts); err != nil {
+		return fmt.Errorf("failed to write version %s: %v", version.ID, err)
+	}
+	if oldID == version.ID {
+		return nil
+	}
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": oldID}); err != nil {
+		return fmt.Errorf("failed to remove version %s: %v", oldID, err)
+	}
+	return nil
+}
+
+func (s *memorySink) Move(ctx context.Context, move Relocation) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var moving []mongoWrite.Document
+	for id, doc := range s.docs {
+		sourceFile, _ := doc["SourceFile"].(string)
+		if isUnderPath(sourceFile, move.NewPath) && !doc.Bool("Deleted") {
+			doc["Deleted"] = true
+			doc["DeletedTime"] = time.Now()
+		}
+		if isUnderPath(sourceFile, move.OldPath) && !doc.Bool("Deleted") {
+			moving = append(moving, doc)
+			delete(s.docs, id)
+		}
+	}
+	for _, doc := range moving {
+		moved := move.Document(doc)
+		s.docs[moved.ID()] = moved
+	}
+
+	var nodes []mongoWrite.TreeNodeRecord
+	for id, node := range s.tree {
+		if isUnderPath(node.SourceFile, move.NewPath) {
+			delete(s.tree, id)
+		} else if isUnderPath(node.SourceFile, move.OldPath) {
+			nodes = append(nodes, node)
+			delete(s.tree, id)
+		}
+	}
+	for _, node := range nodes {
+		node = move.TreeNode(node)
+		s.tree[node.ID] = node
+	}
+
+	var versions []mongoWrite.FileVersionRecord
+	for id, version := range s.versions {
+		if isUnderPath(version.SourceFile, move.OldPath) {
+			versions = append(versions, version)
+			delete(s.versions, id)
+		}
+	}
+	for _, version := range versions {
+		version = move.Version(version)
+		if existing, ok := s.versions[version.ID]; ok {
+			if existing.FirstSeenTime.Before(version.FirstSeenTime) {
+				version.FirstSeenTime = existing.FirstSeenTime
+			}
+			if existing.LastSeenTime.After(version.LastSeenTime) {
+				version.LastSeenTime = existing.LastSeenTime
+			}
+		}
+		s.versions[version.ID] = version
+	}
+	return int64(len(moving)), nil
+}
@@ -0,0 +1,137 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"RSKGroup/OPIe/utils/fileHash"
+)
+
+// Moving a directory should carry the file versions below it over to the new
+// path, merging into a version with the same content already there.
+func TestMoveVersions(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	ix, err := New(Options{Sink: "memory", IdStrategy: "path", ExiftoolPath: filepath.Join(root, "no-exiftool")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := ix.Sink().(*memorySink)
+
+	index := func(pathValue, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(pathValue), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(pathValue, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fileInfo, err := os.Lstat(pathValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ix.IndexPath(ctx, pathValue, root, fileInfo, DirectoryTotals{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldDir, newDir := filepath.Join(root, "old"), filepath.Join(root, "new")
+	index(filepath.Join(oldDir, "a"), "one")
+	index(filepath.Join(oldDir, "a"), "two")
+	index(filepath.Join(oldDir, "sub", "b"), "three")
+	// What the rename replaces, with the same content as old/a once had
+	index(filepath.Join(newDir, "a"), "one")
+	index(filepath.Join(root, "other"), "four")
+	firstSeen := make(map[string]time.Time)
+	for _, version := range sink.versions {
+		if previous, ok := firstSeen[version.FileHash]; !ok || version.FirstSeenTime.Before(previous) {
+			firstSeen[version.FileHash] = version.FirstSeenTime
+		}
+	}
+
+	if err := os.RemoveAll(newDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.Move(ctx, oldDir, newDir, root); err != nil {
+		t.Fatal(err)
+	}
+
+	have := make(map[string]int)
+	for id, version := range sink.versions {
+		if want := hashPath(fileHash.SHA1, version.SourceFile) + ":" + version.FileHash; id != want || version.ID != want {
+			t.Errorf("version of %s stored as %s, want %s", version.SourceFile, id, want)
+		}
+		if version.SourcePathHash != hashPath(fileHash.SHA1, version.SourceFile) {
+			t.Errorf("version of %s has path hash %s", version.SourceFile, version.SourcePathHash)
+		}
+		if !version.FirstSeenTime.Equal(firstSeen[version.FileHash]) {
+			t.Errorf("version of %s first seen %v, want %v", version.SourceFile, version.FirstSeenTime, firstSeen[version.FileHash])
+		}
+		rel, _ := filepath.Rel(root, version.SourceFile)
+		have[rel]++
+	}
+	want := map[string]int{"new/a": 2, "new/sub/b": 1, "other": 1}
+	if len(have) != len(want) {
+		t.Errorf("versions by path: have %v, want %v", have, want)
+	}
+	for rel, n := range want {
+		if have[rel] != n {
+			t.Errorf("versions by path: have %v, want %v", have, want)
+			break
+		}
+	}
+}
+
+// Ids and path hashes should be made with PathHashAlgo, before and after a
+// move.
+func TestMovePathHashAlgo(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	ix, err := New(Options{Sink: "memory", IdStrategy: "path", PathHashAlgo: "sha256", ExiftoolPath: filepath.Join(root, "no-exiftool")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := ix.Sink().(*memorySink)
+
+	check := func(pathValue string) {
+		t.Helper()
+		want := hashPath(fileHash.SHA256, pathValue)
+		doc, ok := sink.docs[want]
+		if !ok {
+			t.Fatalf("no document for %s under %s", pathValue, want)
+		}
+		if doc["SourcePathHash"] != want {
+			t.Errorf("%s has path hash %v, want %s", pathValue, doc["SourcePathHash"], want)
+		}
+		if want := hashPath(fileHash.SHA256, filepath.Dir(pathValue)); doc["DirectoryHash"] != want {
+			t.Errorf("%s has directory hash %v, want %s", pathValue, doc["DirectoryHash"], want)
+		}
+	}
+
+	oldPath, newPath := filepath.Join(root, "old"), filepath.Join(root, "new")
+	if err := os.WriteFile(oldPath, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Lstat(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.IndexPath(ctx, oldPath, root, fileInfo, DirectoryTotals{}); err != nil {
+		t.Fatal(err)
+	}
+	check(oldPath)
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.Move(ctx, oldPath, newPath, root); err != nil {
+		t.Fatal(err)
+	}
+	check(newPath)
+}
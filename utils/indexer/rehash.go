@@ -0,0 +1,194 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"RSKGroup/OPIe/utils/fileHash"
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IndexRehasher is implemented by sinks whose stored file documents can be
+// hashed again. Rehash updates documents hashed with a different algorithm;
+// DeepHash fills in the full hash of documents that only have a quick
+// fingerprint. Both return how many documents were updated.
+// Both hash with the indexer's algorithm and store documents under its id
+// strategy.
+type IndexRehasher interface {
+	Rehash(ctx context.Context, ix *Indexer, workers int) (int64, error)
+	DeepHash(ctx context.Context, ix *Indexer, workers int) (int64, error)
+}
+
+// The algorithm a stored document's FileHash was computed with. Documents
+// from before FileHashAlgo existed were hashed with sha1.
+func storedHashAlgo(doc mongoWrite.Document) string {
+	if algo, _ := doc["FileHashAlgo"].(string); algo != "" {
+		return algo
+	}
+	return fileHash.SHA1.String()
+}
+
+// Fully hash a stored file document's file again, refreshing its quick
+// fingerprint too if it has one. It returns the document as it should now be
+// stored, which has a new _id under the content id strategy. A file whose
+// size or modification time changed since it was indexed, or while it was
+// hashed, is left for the next scan.
+func (ix *Indexer) rehashDocument(doc mongoWrite.Document) (mongoWrite.Document, error) {
+	algo := ix.hashAlgo
+	sourceFile, _ := doc["SourceFile"].(string)
+	if err := checkUnchanged(sourceFile, doc); err != nil {
+		return nil, err
+	}
+	hash, err := algo.File(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUnchanged(sourceFile, doc); err != nil {
+		return nil, err
+	}
+
+	updated := make(mongoWrite.Document, len(doc))
+	for key, value := range doc {
+		updated[key] = value
+	}
+	if quickHash, _ := doc["FileQuickHash"].(string); quickHash != "" && storedHashAlgo(doc) != algo.String() {
+		if updated["FileQuickHash"], err = algo.Fingerprint(sourceFile, ix.quickHashSample); err != nil {
+			return nil, err
+		}
+	}
+	updated["_id"] = ix.idStrategy.ForFile(hashPath(ix.pathHashAlgo, sourceFile), hash)
+	updated["FileHash"] = hash
+	updated["FileHashAlgo"] = algo.String()
+	updated["FileHashPending"] = false
+	updated["HashError"] = ""
+	return updated, nil
+}
+
+// Make sure a file still has the size and modification time its stored
+// document has. BSON dates only keep milliseconds.
+func checkUnchanged(sourceFile string, doc mongoWrite.Document) error {
+	fileInfo, err := readFileInfo(sourceFile)
+	if err != nil {
+		return err
+	}
+	if fileInfo.Size() != doc.Int64("FileSizeRaw") ||
+		!doc.Time("FileModTime").Equal(fileInfo.ModTime().Truncate(time.Millisecond)) {
+		return fmt.Errorf("file changed since it was indexed")
+	}
+	return nil
+}
+
+func (s *mongoSink) Rehash(ctx context.Context, ix *Indexer, workers int) (int64, error) {
+	if s.bulk != nil {
+		s.bulk.writePending()
+	}
+	return rehashInDB(ctx, s, rehashFilter(ix.hashAlgo), ix, workers)
+}
+
+// The live file documents hashed with another algorithm than algo. Documents
+// from before typed records store their flags as strings, and those from
+// before FileHashAlgo existed were hashed with sha1, as storedHashAlgo has it.
+func rehashFilter(algo fileHash.Algorithm) bson.M {
+	filter := bson.M{
+		"IsDirectory":  bson.M{"$nin": bson.A{true, "true"}},
+		"IsSymLink":    bson.M{"$nin": bson.A{true, "true"}},
+		"Deleted":      bson.M{"$ne": true},
+		"FileHashAlgo": bson.M{"$ne": algo.String()},
+	}
+	if algo == fileHash.SHA1 {
+		// A null in $nin also excludes documents without the field
+		filter["FileHashAlgo"] = bson.M{"$nin": bson.A{algo.String(), "", nil}}
+	}
+	return filter
+}
+
+func (s *mongoSink) DeepHash(ctx context.Context, ix *Indexer, workers int) (int64, error) {
+	if s.bulk != nil {
+		s.bulk.writePending()
+	}
+	filter := bson.M{
+		"FileHashPending": true,
+		"Deleted":         bson.M{"$ne": true},
+	}
+	return rehashInDB(ctx, s, filter, ix, workers)
+}
+
+// Fully hash every live file document in MongoDB matching the filter. Files
+// that can no longer be read are reported and left as they are, so a later
+// run can retry them.
+func rehashInDB(ctx context.Context, s *mongoSink, filter bson.M, ix *Indexer, workers int) (int64, error) {
+	cursor, err := s.collection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	if workers <= 0 {
+		workers = 1
+	}
+	var rehashed int64
+	var wg sync.WaitGroup
+	docs := make(chan mongoWrite.Document, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range docs {
+				// Once cancelled, what is left is only drained
+				if ctx.Err() != nil {
+					continue
+				}
+				sourceFile, _ := doc["SourceFile"].(string)
+				updated, err := ix.rehashDocument(doc)
+				if err != nil {
+					ix.ReportError(sourceFile, StageHash, err)
+					continue
+				}
+				if err := replaceDocumentInDB(ctx, s.collection, doc.ID(), updated); err != nil {
+					ix.ReportError(sourceFile, StageWrite, err)
+					continue
+				}
+				if err := recordVersion(ctx, s, updated, updated.Time("LastSeenTime")); err != nil {
+					ix.ReportError(sourceFile, StageWrite, err)
+				}
+				atomic.AddInt64(&rehashed, 1)
+			}
+		}()
+	}
+
+	for cursor.Next(ctx) {
+		var doc mongoWrite.Document
+		if err = cursor.Decode(&doc); err != nil {
+			break
+		}
+		docs <- doc
+	}
+	close(docs)
+	wg.Wait()
+
+	if err != nil {
+		return rehashed, err
+	}
+	return rehashed, cursor.Err()
+}
+
+// Store a document under its new _id, removing the old one if it moved
+func replaceDocumentInDB(ctx context.Context, collection *mongo.Collection, oldID string, doc mongoWrite.Document) error {
+	opts := options.Replace().SetUpsert(true)
+	if _, err := collection.ReplaceOne(ctx, bson.M{"_id": doc.ID()}, doc, opts); err != nil {
+		return fmt.Errorf("failed to write %s: %v", doc.ID(), err)
+	}
+	if doc.ID() == oldID {
+		return nil
+	}
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": oldID}); err != nil {
+		return fmt.Errorf("failed to remove %s: %v", oldID, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"RSKGroup/OPIe/utils/mongoWrite"
+)
+
+// A file is only hashed again while it has the size and modification time
+// of its stored document
+func TestCheckUnchanged(t *testing.T) {
+	pathValue := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(pathValue, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 6789000, time.UTC)
+	if err := os.Chtimes(pathValue, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		size    int64
+		modTime time.Time
+		changed bool
+	}{
+		{"unchanged", 3, modTime.Truncate(time.Millisecond), false},
+		{"size changed", 4, modTime.Truncate(time.Millisecond), true},
+		{"touched", 3, modTime.Add(-time.Second).Truncate(time.Millisecond), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := mongoWrite.Document{"FileSizeRaw": tt.size, "FileModTime": tt.modTime}
+			if err := checkUnchanged(pathValue, doc); (err != nil) != tt.changed {
+				t.Errorf("checkUnchanged: have %v, want changed %v", err, tt.changed)
+			}
+		})
+	}
+}
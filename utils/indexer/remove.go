@@ -0,0 +1,86 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"RSKGroup/OPIe/utils/mongoWrite"
+)
+
+// IndexRemover is implemented by sinks that can mark the documents for a
+// path, and for everything that was below it, deleted or remove them.
+// Remove returns how many were tombstoned.
+type IndexRemover interface {
+	Remove(ctx context.Context, pathValue string, purge bool) (int64, error)
+}
+
+// Remove tombstones the documents for a path that no longer exists, and for
+// everything that was below it, or deletes them when purge is set
+func (ix *Indexer) Remove(ctx context.Context, pathValue string, purge bool) (int64, error) {
+	remover, ok := ix.sink.(IndexRemover)
+	if !ok {
+		return 0, fmt.Errorf("sink %T can't remove documents: %w", ix.sink, ErrNotSupported)
+	}
+	return remover.Remove(ctx, pathValue, purge)
+}
+
+func (s *mongoSink) Remove(ctx context.Context, pathValue string, purge bool) (int64, error) {
+	if s.bulk != nil {
+		// Make sure a queued upsert can't bring a document back afterwards
+		s.bulk.writePending()
+	}
+	if s.tree != nil {
+		if s.treeBulk != nil {
+			s.treeBulk.writePending()
+		}
+		// Tree nodes are never tombstoned, as in a sweep
+		if _, err := s.tree.DeleteMany(ctx, underPathFilter(pathValue)); err != nil {
+			return 0, err
+		}
+	}
+	return tombstoneInDB(ctx, s.collection, underPathFilter(pathValue), purge)
+}
+
+// A removal is recorded as a line holding the path, its hash and "Deleted":
+// true, standing for the path and everything below it
+func (s *jsonLinesSink) Remove(ctx context.Context, pathValue string, purge bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.encoder.Encode(mongoWrite.Document{
+		"SourceFile":     pathValue,
+		"SourcePathHash": hashPath(s.pathHashAlgo, pathValue),
+		"Deleted":        true,
+		"DeletedTime":    time.Now(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (s *memorySink) Remove(ctx context.Context, pathValue string, purge bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for id, doc := range s.docs {
+		sourceFile, _ := doc["SourceFile"].(string)
+		if !isUnderPath(sourceFile, pathValue) || doc.Bool("Deleted") {
+			continue
+		}
+		if purge {
+			delete(s.docs, id)
+		} else {
+			doc["Deleted"] = true
+			doc["DeletedTime"] = time.Now()
+		}
+		count++
+	}
+	for id, node := range s.tree {
+		if isUnderPath(node.SourceFile, pathValue) {
+			delete(s.tree, id)
+		}
+	}
+	return count, nil
+}
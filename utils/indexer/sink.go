@@ -0,0 +1,345 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"RSKGroup/OPIe/utils/documentId"
+	"RSKGroup/OPIe/utils/fileHash"
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IndexSink is the destination for compiled index documents. Every document
+// carries its own "_id", which sinks use to upsert and delete.
+type IndexSink interface {
+	Write(ctx context.Context, data mongoWrite.Document) error
+	WriteBatch(ctx context.Context, data []mongoWrite.Document) error
+	Delete(ctx context.Context, id string) error
+	Close() error
+}
+
+// Create the index sink selected by the Sink setting in conf.json
+func newIndexSink(config Options, ids documentId.Strategy, pathHash fileHash.Algorithm) (IndexSink, error) {
+	switch config.Sink {
+	case "", "mongodb":
+		collection, err := connectToMongoDB(config.DbType, config.Host, config.Port, config.DbUser, config.DbPwd, config.DbName, config.FileColl)
+		if err != nil {
+			return nil, err
+		}
+		sink := &mongoSink{collection: collection, ids: ids, pathHashAlgo: pathHash}
+		sink.versions = openVersionCollection(collection, config.VersionColl)
+		sink.tree = openTreeCollection(collection, config.TreeColl)
+		if err := sink.createIndexes(context.Background()); err != nil {
+			return nil, err
+		}
+		if config.BatchSize > 1 {
+			flushInterval := time.Duration(config.FlushSeconds) * time.Second
+			sink.bulk = newBulkWriter(collection, config.BatchSize, flushInterval)
+			if sink.versions != nil {
+				sink.versionBulk = newBulkWriter(sink.versions, config.BatchSize, flushInterval)
+			}
+			if sink.tree != nil {
+				sink.treeBulk = newBulkWriter(sink.tree, config.BatchSize, flushInterval)
+			}
+		}
+		return sink, nil
+	case "jsonl":
+		return newJSONLinesSink(config.SinkPath, pathHash)
+	case "memory":
+		return newMemorySink(), nil
+	default:
+		return nil, fmt.Errorf("unknown sink type: %s", config.Sink)
+	}
+}
+
+// MONGODB SINK
+
+// mongoSink upserts documents into a MongoDB collection, one UpdateOne per
+// document or through a bulkWriter when BatchSize is configured. File
+// versions and directory tree nodes go to their own collections when
+// VersionColl and TreeColl are configured.
+type mongoSink struct {
+	collection   *mongo.Collection
+	ids          documentId.Strategy
+	pathHashAlgo fileHash.Algorithm
+	bulk         *bulkWriter
+	versions     *mongo.Collection
+	versionBulk  *bulkWriter
+	tree         *mongo.Collection
+	treeBulk     *bulkWriter
+}
+
+// Create the indexes the sink's queries rely on, if they don't exist yet:
+// lookups by path hash for incremental scans, path prefixes for sweeps,
+// moves and removals, and the children of a directory for recounts
+func (s *mongoSink) createIndexes(ctx context.Context) error {
+	sourceFile := mongo.IndexModel{Keys: bson.D{{Key: "SourceFile", Value: 1}}}
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		s.collection: {
+			{Keys: bson.D{{Key: "SourcePathHash", Value: 1}, {Key: "IndexTime", Value: -1}}},
+			sourceFile,
+			{Keys: bson.D{{Key: "DirectoryHash", Value: 1}}},
+		},
+	}
+	if s.versions != nil {
+		indexes[s.versions] = []mongo.IndexModel{sourceFile}
+	}
+	if s.tree != nil {
+		indexes[s.tree] = []mongo.IndexModel{sourceFile}
+	}
+	for collection, models := range indexes {
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("failed to create indexes on %s: %v", collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (s *mongoSink) Write(ctx context.Context, data mongoWrite.Document) error {
+	if s.bulk != nil {
+		s.bulk.Upsert(ctx, data)
+		return nil
+	}
+	return saveDataToDB(ctx, s.collection, data)
+}
+
+func (s *mongoSink) WriteBatch(ctx context.Context, data []mongoWrite.Document) error {
+	for _, doc := range data {
+		if err := s.Write(ctx, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *mongoSink) Delete(ctx context.Context, id string) error {
+	if s.bulk != nil {
+		// Make sure a queued upsert can't land after the delete
+		s.bulk.writePending()
+	}
+	return deleteDataFromDB(ctx, s.collection, id)
+}
+
+// Flush writes everything queued, and returns an error if any document
+// failed to write since the last Flush
+func (s *mongoSink) Flush() error {
+	var err error
+	for _, bulk := range []*bulkWriter{s.bulk, s.versionBulk, s.treeBulk} {
+		if bulk == nil {
+			continue
+		}
+		if flushErr := bulk.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// Report the documents the bulk writers fail to write as write errors of
+// the indexer's run. Only failed file and directory documents count against
+// what was written; versions and tree nodes are just reported.
+func (s *mongoSink) reportFailuresTo(ix *Indexer) {
+	if s.bulk != nil {
+		s.bulk.onFailure = ix.writeFailed
+	}
+	for _, bulk := range []*bulkWriter{s.versionBulk, s.treeBulk} {
+		if bulk != nil {
+			bulk.onFailure = func(write queuedWrite, err error) {
+				ix.ReportError(write.Path, StageWrite, err)
+			}
+		}
+	}
+}
+
+func (s *mongoSink) Close() error {
+	var err error
+	if s.bulk != nil {
+		err = s.bulk.Close()
+	}
+	for _, bulk := range []*bulkWriter{s.versionBulk, s.treeBulk} {
+		if bulk == nil {
+			continue
+		}
+		if bulkErr := bulk.Close(); err == nil {
+			err = bulkErr
+		}
+	}
+	if disconnectErr := s.collection.Database().Client().Disconnect(context.Background()); err == nil {
+		err = disconnectErr
+	}
+	return err
+}
+
+// Connect to MongoDB and return the collection
+func connectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName, collectionName string) (*mongo.Collection, error) {
+	_, collection, err := mongoWrite.ConnectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName, collectionName)
+	return collection, err
+}
+
+// Save data to MongoDB
+func saveDataToDB(ctx context.Context, collection *mongo.Collection, data mongoWrite.Document) error {
+	return mongoWrite.UpsertDocument(ctx, collection, data)
+}
+
+// Delete a document from MongoDB by _id
+func deleteDataFromDB(ctx context.Context, collection *mongo.Collection, id string) error {
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+// JSON-LINES SINK
+
+// jsonLinesSink appends one JSON document per line to a file. Deletes are
+// recorded as a line holding only the "_id" and "Deleted": true.
+type jsonLinesSink struct {
+	mu           sync.Mutex
+	file         *os.File
+	encoder      *json.Encoder
+	pathHashAlgo fileHash.Algorithm
+}
+
+// Open (or create) the JSON-Lines output file for appending
+func newJSONLinesSink(filename string, pathHash fileHash.Algorithm) (*jsonLinesSink, error) {
+	if filename == "" {
+		return nil, fmt.Errorf("jsonl sink requires SinkPath")
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sink file: %v", err)
+	}
+	return &jsonLinesSink{file: f, encoder: json.NewEncoder(f), pathHashAlgo: pathHash}, nil
+}
+
+func (s *jsonLinesSink) Write(ctx context.Context, data mongoWrite.Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(data)
+}
+
+func (s *jsonLinesSink) WriteBatch(ctx context.Context, data []mongoWrite.Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, doc := range data {
+		if err := s.encoder.Encode(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *jsonLinesSink) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(mongoWrite.Document{"_id": id, "Deleted": true})
+}
+
+func (s *jsonLinesSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Sync()
+}
+
+func (s *jsonLinesSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// MEMORY SINK
+
+// IndexCounter is implemented by sinks that can tell how much they hold,
+// for programs to report after a run
+type IndexCounter interface {
+	Counts() (documents, versions, treeNodes int)
+}
+
+// memorySink keeps documents in a map keyed by "_id", mainly for dry runs
+// and tests
+type memorySink struct {
+	mu       sync.Mutex
+	docs     map[string]mongoWrite.Document
+	versions map[string]mongoWrite.FileVersionRecord
+	tree     map[string]mongoWrite.TreeNodeRecord
+}
+
+func newMemorySink() *memorySink {
+	return &memorySink{
+		docs:     make(map[string]mongoWrite.Document),
+		versions: make(map[string]mongoWrite.FileVersionRecord),
+		tree:     make(map[string]mongoWrite.TreeNodeRecord),
+	}
+}
+
+func (s *memorySink) Write(ctx context.Context, data mongoWrite.Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upsert(data)
+	return nil
+}
+
+func (s *memorySink) WriteBatch(ctx context.Context, data []mongoWrite.Document) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, doc := range data {
+		s.upsert(doc)
+	}
+	return nil
+}
+
+// Merge the document into any existing one, like a MongoDB $set upsert
+func (s *memorySink) upsert(data mongoWrite.Document) {
+	doc, ok := s.docs[data.ID()]
+	if !ok {
+		doc = make(mongoWrite.Document, len(data))
+		s.docs[data.ID()] = doc
+	}
+	for key, value := range data {
+		doc[key] = value
+	}
+}
+
+// Deleting the last document for a path deletes its file versions and tree
+// node along with it
+func (s *memorySink) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok := s.docs[id]
+	if !ok {
+		return nil
+	}
+	delete(s.docs, id)
+	sourceFile, _ := doc["SourceFile"].(string)
+	for _, other := range s.docs {
+		if other["SourceFile"] == sourceFile {
+			return nil
+		}
+	}
+	for versionID, version := range s.versions {
+		if version.SourceFile == sourceFile {
+			delete(s.versions, versionID)
+		}
+	}
+	for nodeID, node := range s.tree {
+		if node.SourceFile == sourceFile {
+			delete(s.tree, nodeID)
+		}
+	}
+	return nil
+}
+
+func (s *memorySink) Counts() (documents, versions, treeNodes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.docs), len(s.versions), len(s.tree)
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"RSKGroup/OPIe/utils/mongoWrite"
+)
+
+// Deleting the last document for a path should take its file versions and
+// tree node with it, and leave those of other paths alone.
+func TestMemorySinkDelete(t *testing.T) {
+	ctx := context.Background()
+	sink := newMemorySink()
+	for _, doc := range []mongoWrite.Document{
+		{"_id": "a:1", "SourceFile": "/r/a"},
+		{"_id": "a:2", "SourceFile": "/r/a"},
+		{"_id": "b", "SourceFile": "/r/b"},
+	} {
+		if err := sink.Write(ctx, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.versions["a:1"] = mongoWrite.FileVersionRecord{ID: "a:1", SourceFile: "/r/a"}
+	sink.versions["b:1"] = mongoWrite.FileVersionRecord{ID: "b:1", SourceFile: "/r/b"}
+	sink.tree["a"] = mongoWrite.TreeNodeRecord{ID: "a", SourceFile: "/r/a"}
+	sink.tree["b"] = mongoWrite.TreeNodeRecord{ID: "b", SourceFile: "/r/b"}
+
+	if err := sink.Delete(ctx, "a:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sink.versions["a:1"]; !ok {
+		t.Error("version deleted while another document for its path is left")
+	}
+	if err := sink.Delete(ctx, "a:2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sink.versions["a:1"]; ok {
+		t.Error("version of a deleted path left behind")
+	}
+	if _, ok := sink.tree["a"]; ok {
+		t.Error("tree node of a deleted path left behind")
+	}
+	if documents, versions, treeNodes := sink.Counts(); documents != 1 || versions != 1 || treeNodes != 1 {
+		t.Errorf("have %d documents, %d versions and %d tree nodes, want 1 of each", documents, versions, treeNodes)
+	}
+}
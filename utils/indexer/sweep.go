@@ -0,0 +1,109 @@
+package indexer
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IndexSweeper is implemented by sinks that can find documents under a
+// scanned path which were not stamped by the current run, and either mark
+// them deleted or remove them. Sweep returns how many were tombstoned.
+type IndexSweeper interface {
+	Sweep(ctx context.Context, pathValue, runID string, purge bool) (int64, error)
+}
+
+// NewRunID creates an identifier for a run, stamped on every document it
+// writes
+func NewRunID(startTime time.Time) string {
+	return startTime.Format("20060102-150405.000000")
+}
+
+func (s *mongoSink) Sweep(ctx context.Context, pathValue, runID string, purge bool) (int64, error) {
+	if s.bulk != nil {
+		// Everything from this run must be stamped before we look for stale documents
+		s.bulk.writePending()
+	}
+	if s.tree != nil {
+		if s.treeBulk != nil {
+			s.treeBulk.writePending()
+		}
+		if err := sweepStaleTreeInDB(ctx, s.tree, pathValue, runID); err != nil {
+			return 0, err
+		}
+	}
+	return sweepStaleInDB(ctx, s.collection, pathValue, runID, purge)
+}
+
+func (s *memorySink) Sweep(ctx context.Context, pathValue, runID string, purge bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for id, doc := range s.docs {
+		sourceFile, _ := doc["SourceFile"].(string)
+		if !isUnderPath(sourceFile, pathValue) || doc["RunID"] == runID || doc.Bool("Deleted") {
+			continue
+		}
+		if purge {
+			delete(s.docs, id)
+		} else {
+			doc["Deleted"] = true
+			doc["DeletedTime"] = time.Now()
+		}
+		count++
+	}
+	for id, node := range s.tree {
+		if isUnderPath(node.SourceFile, pathValue) && node.RunID != runID {
+			delete(s.tree, id)
+		}
+	}
+	return count, nil
+}
+
+// Mark or delete MongoDB documents under the path that this run didn't see
+func sweepStaleInDB(ctx context.Context, collection *mongo.Collection, pathValue, runID string, purge bool) (int64, error) {
+	filter := underPathFilter(pathValue)
+	filter["RunID"] = bson.M{"$ne": runID}
+	return tombstoneInDB(ctx, collection, filter, purge)
+}
+
+// Mark or delete the MongoDB documents matching a filter that aren't
+// already marked deleted
+func tombstoneInDB(ctx context.Context, collection *mongo.Collection, filter bson.M, purge bool) (int64, error) {
+	filter["Deleted"] = bson.M{"$ne": true}
+
+	if purge {
+		result, err := collection.DeleteMany(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		return result.DeletedCount, nil
+	}
+
+	update := bson.M{"$set": bson.M{
+		"Deleted":     true,
+		"DeletedTime": time.Now(),
+	}}
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// Match the documents for a path and everything below it
+func underPathFilter(pathValue string) bson.M {
+	return bson.M{
+		"SourceFile": bson.M{"$regex": "^" + regexp.QuoteMeta(strings.TrimSuffix(pathValue, "/")) + "(/|$)"},
+	}
+}
+
+// Check whether a path is the given directory or inside it
+func isUnderPath(pathValue, dirValue string) bool {
+	return pathValue == dirValue || strings.HasPrefix(pathValue, strings.TrimSuffix(dirValue, "/")+"/")
+}
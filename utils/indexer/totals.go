@@ -0,0 +1,172 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DirectoryRecounter is implemented by sinks that can bring a directory's
+// totals up to date from the documents of its live children, for programs
+// that index paths one at a time rather than walking whole trees. The
+// directory is given by its SourcePathHash; its document and tree node are
+// updated, if there are any.
+type DirectoryRecounter interface {
+	RecountDirectory(ctx context.Context, pathHash string) error
+}
+
+// UpdateAncestors recounts the totals of every directory above a path, up to
+// the root, after the path was indexed, removed or moved. Each directory is
+// recounted from the stored totals of its children, so the nearest comes
+// first. A sink that can't recount returns ErrNotSupported.
+func (ix *Indexer) UpdateAncestors(ctx context.Context, pathValue, rootValue string) error {
+	recounter, ok := ix.sink.(DirectoryRecounter)
+	if !ok {
+		return fmt.Errorf("sink %T can't recount directories: %w", ix.sink, ErrNotSupported)
+	}
+
+	// Recounts run one at a time, so the last one after a set of changes
+	// sees all of them
+	ix.recountMu.Lock()
+	defer ix.recountMu.Unlock()
+	for _, dir := range ancestryPaths(pathValue, rootValue) {
+		if err := recounter.RecountDirectory(ctx, hashPath(ix.pathHashAlgo, dir)); err != nil {
+			return fmt.Errorf("failed to recount %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// Add a child's document to its directory's totals, as DirectoryTotals.Add
+// does for what a walk finds
+func (t *DirectoryTotals) addDocument(doc mongoWrite.Document) {
+	if doc.Bool("IsDirectory") && !doc.Bool("IsSymLink") {
+		t.ChildDirs++
+		t.DescendantDirs += 1 + doc.Int64("DescendentDirectoryCount")
+		t.DescendantFiles += doc.Int64("DescendentFileCount")
+		t.DescendantSize += doc.Int64("DescendentSizeRaw")
+		return
+	}
+	size := doc.Int64("FileSizeRaw")
+	t.ChildFiles++
+	t.ChildSize += size
+	t.DescendantFiles++
+	t.DescendantSize += size
+}
+
+// The update setting a directory's totals, in its document and tree node
+func totalsUpdate(totals DirectoryTotals) bson.M {
+	return bson.M{"$set": bson.M{
+		"ChildDirectoryCount":      totals.ChildDirs,
+		"ChildFileCount":           totals.ChildFiles,
+		"ChildSizeRaw":             totals.ChildSize,
+		"DescendentDirectoryCount": totals.DescendantDirs,
+		"DescendentFileCount":      totals.DescendantFiles,
+		"DescendentSizeRaw":        totals.DescendantSize,
+	}}
+}
+
+func (s *mongoSink) RecountDirectory(ctx context.Context, pathHash string) error {
+	// Queued writes of the children must land before they are counted
+	for _, bulk := range []*bulkWriter{s.bulk, s.treeBulk} {
+		if bulk != nil {
+			bulk.writePending()
+		}
+	}
+
+	totals, err := recountDirectoryInDB(ctx, s.collection, pathHash)
+	if err != nil {
+		return err
+	}
+	update := totalsUpdate(totals)
+	filter := bson.M{"SourcePathHash": pathHash, "IsDirectory": true, "Deleted": bson.M{"$ne": true}}
+	if _, err := s.collection.UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+	if s.tree != nil {
+		if _, err := s.tree.UpdateOne(ctx, bson.M{"_id": pathHash}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Total the live MongoDB documents directly inside a directory
+func recountDirectoryInDB(ctx context.Context, collection *mongo.Collection, pathHash string) (DirectoryTotals, error) {
+	isDir := bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{"$IsDirectory", true}},
+		bson.M{"$ne": bson.A{"$IsSymLink", true}},
+	}}
+	field := func(name string) bson.M {
+		return bson.M{"$ifNull": bson.A{"$" + name, 0}}
+	}
+	sum := func(ifDir, ifFile interface{}) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{isDir, ifDir, ifFile}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"DirectoryHash": pathHash, "Deleted": bson.M{"$ne": true}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":             nil,
+			"ChildDirs":       sum(1, 0),
+			"ChildFiles":      sum(0, 1),
+			"ChildSize":       sum(0, field("FileSizeRaw")),
+			"DescendantDirs":  sum(bson.M{"$add": bson.A{1, field("DescendentDirectoryCount")}}, 0),
+			"DescendantFiles": sum(field("DescendentFileCount"), 1),
+			"DescendantSize":  sum(field("DescendentSizeRaw"), field("FileSizeRaw")),
+		}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return DirectoryTotals{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var totals DirectoryTotals
+	if cursor.Next(ctx) {
+		var result struct {
+			ChildDirs       int64 `bson:"ChildDirs"`
+			ChildFiles      int64 `bson:"ChildFiles"`
+			ChildSize       int64 `bson:"ChildSize"`
+			DescendantDirs  int64 `bson:"DescendantDirs"`
+			DescendantFiles int64 `bson:"DescendantFiles"`
+			DescendantSize  int64 `bson:"DescendantSize"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return totals, err
+		}
+		totals = DirectoryTotals(result)
+	}
+	return totals, cursor.Err()
+}
+
+func (s *memorySink) RecountDirectory(ctx context.Context, pathHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var totals DirectoryTotals
+	for _, doc := range s.docs {
+		if doc["DirectoryHash"] == pathHash && !doc.Bool("Deleted") {
+			totals.addDocument(doc)
+		}
+	}
+	for _, doc := range s.docs {
+		if doc["SourcePathHash"] == pathHash && doc.Bool("IsDirectory") && !doc.Bool("Deleted") {
+			for key, value := range totalsUpdate(totals)["$set"].(bson.M) {
+				doc[key] = value
+			}
+		}
+	}
+	if node, ok := s.tree[pathHash]; ok {
+		node.ChildDirectoryCount = totals.ChildDirs
+		node.ChildFileCount = totals.ChildFiles
+		node.ChildSizeRaw = totals.ChildSize
+		node.DescendentDirectoryCount = totals.DescendantDirs
+		node.DescendentFileCount = totals.DescendantFiles
+		node.DescendentSizeRaw = totals.DescendantSize
+		s.tree[pathHash] = node
+	}
+	return nil
+}
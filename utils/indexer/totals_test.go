@@ -0,0 +1,89 @@
+package indexer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"RSKGroup/OPIe/utils/fileHash"
+)
+
+// The directories above a change should be recounted from the documents of
+// their children, nearest first, in their documents and tree nodes.
+func TestUpdateAncestors(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	ix, err := New(Options{Sink: "memory", IdStrategy: "path", ExiftoolPath: filepath.Join(root, "no-exiftool")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := ix.Sink().(*memorySink)
+
+	index := func(pathValue string) {
+		t.Helper()
+		fileInfo, err := os.Lstat(pathValue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ix.IndexPath(ctx, pathValue, root, fileInfo, DirectoryTotals{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ix.UpdateAncestors(ctx, pathValue, root); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(pathValue, content string) {
+		t.Helper()
+		if err := os.WriteFile(pathValue, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		index(pathValue)
+	}
+	check := func(dir string, want DirectoryTotals) {
+		t.Helper()
+		pathHash := hashPath(fileHash.SHA1, dir)
+		doc := sink.docs[pathHash]
+		have := DirectoryTotals{
+			ChildDirs:       doc.Int64("ChildDirectoryCount"),
+			ChildFiles:      doc.Int64("ChildFileCount"),
+			ChildSize:       doc.Int64("ChildSizeRaw"),
+			DescendantDirs:  doc.Int64("DescendentDirectoryCount"),
+			DescendantFiles: doc.Int64("DescendentFileCount"),
+			DescendantSize:  doc.Int64("DescendentSizeRaw"),
+		}
+		if have != want {
+			t.Errorf("%s: have %+v, want %+v", dir, have, want)
+		}
+		node := sink.tree[pathHash]
+		if node.ChildFileCount != want.ChildFiles || node.DescendentSizeRaw != want.DescendantSize {
+			t.Errorf("%s tree node: have %+v, want %+v", dir, node, want)
+		}
+	}
+
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	index(root)
+	index(sub)
+	write(filepath.Join(root, "a"), "12345")
+	write(filepath.Join(sub, "b"), "123")
+	write(filepath.Join(sub, "c"), "1")
+	check(sub, DirectoryTotals{ChildFiles: 2, ChildSize: 4, DescendantFiles: 2, DescendantSize: 4})
+	check(root, DirectoryTotals{ChildDirs: 1, ChildFiles: 1, ChildSize: 5, DescendantDirs: 1, DescendantFiles: 3, DescendantSize: 9})
+
+	// A file growing, and one removed
+	write(filepath.Join(sub, "b"), "123456")
+	if err := os.Remove(filepath.Join(sub, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ix.Remove(ctx, filepath.Join(sub, "c"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.UpdateAncestors(ctx, filepath.Join(sub, "c"), root); err != nil {
+		t.Fatal(err)
+	}
+	check(sub, DirectoryTotals{ChildFiles: 1, ChildSize: 6, DescendantFiles: 1, DescendantSize: 6})
+	check(root, DirectoryTotals{ChildDirs: 1, ChildFiles: 1, ChildSize: 5, DescendantDirs: 1, DescendantFiles: 2, DescendantSize: 11})
+}
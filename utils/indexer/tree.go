@@ -0,0 +1,106 @@
+package indexer
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TreeWriter is implemented by sinks that maintain the directory tree
+// collection configured as TreeColl
+type TreeWriter interface {
+	WriteTreeNode(ctx context.Context, node mongoWrite.TreeNodeRecord) error
+}
+
+// Write a tree node for a directory document, if the sink keeps a tree
+func writeTreeNode(ctx context.Context, sink IndexSink, doc mongoWrite.Document, rootValue string) error {
+	writer, ok := sink.(TreeWriter)
+	// Followed links to directories are part of the tree like any other
+	if !ok || !doc.Bool("IsDirectory") {
+		return nil
+	}
+
+	sourceFile, _ := doc["SourceFile"].(string)
+	fileName, _ := doc["FileName"].(string)
+	parentHash, _ := doc["DirectoryHash"].(string)
+	pathHash, _ := doc["SourcePathHash"].(string)
+	runID, _ := doc["RunID"].(string)
+
+	var ancestors []string
+	if hashes, ok := doc["AncestryPathHashes"].(bson.A); ok {
+		for _, hash := range hashes {
+			if value, ok := hash.(string); ok {
+				ancestors = append(ancestors, value)
+			}
+		}
+	}
+
+	return writer.WriteTreeNode(ctx, mongoWrite.TreeNodeRecord{
+		ID:                       pathHash,
+		SourceFile:               sourceFile,
+		FileName:                 fileName,
+		ParentHash:               parentHash,
+		AncestryPathHashes:       ancestors,
+		Depth:                    treeDepth(sourceFile, rootValue),
+		ChildDirectoryCount:      doc.Int64("ChildDirectoryCount"),
+		ChildFileCount:           doc.Int64("ChildFileCount"),
+		ChildSizeRaw:             doc.Int64("ChildSizeRaw"),
+		DescendentDirectoryCount: doc.Int64("DescendentDirectoryCount"),
+		DescendentFileCount:      doc.Int64("DescendentFileCount"),
+		DescendentSizeRaw:        doc.Int64("DescendentSizeRaw"),
+		IndexTime:                doc.Time("IndexTime"),
+		RunID:                    runID,
+	})
+}
+
+// Count how many levels a directory is below the root
+func treeDepth(pathValue, rootValue string) int64 {
+	rel, err := filepath.Rel(rootValue, pathValue)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return int64(strings.Count(rel, string(filepath.Separator)) + 1)
+}
+
+func (s *mongoSink) WriteTreeNode(ctx context.Context, node mongoWrite.TreeNodeRecord) error {
+	if s.tree == nil {
+		return nil
+	}
+	doc, err := mongoWrite.ToDocument(node)
+	if err != nil {
+		return err
+	}
+	if s.treeBulk != nil {
+		s.treeBulk.Upsert(ctx, doc)
+		return nil
+	}
+	return mongoWrite.UpsertDocument(ctx, s.tree, doc)
+}
+
+func (s *memorySink) WriteTreeNode(ctx context.Context, node mongoWrite.TreeNodeRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tree[node.ID] = node
+	return nil
+}
+
+// Open the tree collection next to the file collection
+func openTreeCollection(collection *mongo.Collection, name string) *mongo.Collection {
+	if name == "" {
+		return nil
+	}
+	return collection.Database().Collection(name)
+}
+
+// Remove tree nodes under the path that this run didn't see. Tree nodes are
+// never tombstoned; the file collection keeps the deleted directory's record.
+func sweepStaleTreeInDB(ctx context.Context, collection *mongo.Collection, pathValue, runID string) error {
+	filter := underPathFilter(pathValue)
+	filter["RunID"] = bson.M{"$ne": runID}
+	_, err := collection.DeleteMany(ctx, filter)
+	return err
+}
@@ -0,0 +1,69 @@
+package indexer
+
+import (
+	"context"
+	"time"
+
+	"RSKGroup/OPIe/utils/mongoWrite"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// VersionRecorder is implemented by sinks that keep a history of every
+// distinct content hash seen at a path alongside the current-state document
+type VersionRecorder interface {
+	RecordVersion(ctx context.Context, version mongoWrite.FileVersionRecord) error
+}
+
+// Record the content of a regular file's document as a version, if the sink
+// keeps history
+func recordVersion(ctx context.Context, sink IndexSink, doc mongoWrite.Document, seen time.Time) error {
+	recorder, ok := sink.(VersionRecorder)
+	fileHash, _ := doc["FileHash"].(string)
+	if !ok || fileHash == "" || doc.Bool("IsDirectory") || doc.Bool("IsSymLink") {
+		return nil
+	}
+
+	sourceFile, _ := doc["SourceFile"].(string)
+	pathHash, _ := doc["SourcePathHash"].(string)
+	return recorder.RecordVersion(ctx, mongoWrite.FileVersionRecord{
+		ID:             pathHash + ":" + fileHash,
+		SourceFile:     sourceFile,
+		SourcePathHash: pathHash,
+		FileHash:       fileHash,
+		FileHashAlgo:   storedHashAlgo(doc),
+		FileSizeRaw:    doc.Int64("FileSizeRaw"),
+		FileModTime:    doc.Time("FileModTime"),
+		FirstSeenTime:  seen,
+		LastSeenTime:   seen,
+	})
+}
+
+func (s *mongoSink) RecordVersion(ctx context.Context, version mongoWrite.FileVersionRecord) error {
+	if s.versions == nil {
+		return nil
+	}
+	if s.versionBulk != nil {
+		s.versionBulk.Add(ctx, mongoWrite.FileVersionModel(version), queuedWrite{ID: version.ID, Path: version.SourceFile})
+		return nil
+	}
+	return mongoWrite.UpsertFileVersion(ctx, s.versions, version)
+}
+
+func (s *memorySink) RecordVersion(ctx context.Context, version mongoWrite.FileVersionRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.versions[version.ID]; ok {
+		version.FirstSeenTime = existing.FirstSeenTime
+	}
+	s.versions[version.ID] = version
+	return nil
+}
+
+// Open the versions collection next to the file collection
+func openVersionCollection(collection *mongo.Collection, name string) *mongo.Collection {
+	if name == "" {
+		return nil
+	}
+	return collection.Database().Collection(name)
+}
@@ -1,58 +1,15 @@
-package main
+package mongoWrite
 
 import (
 	"context"
 	"fmt"
-	"os"
-	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type FileData struct {
-	IsDirectory         bool   `json:"IsDirectory"`
-	FileSizeRaw         int64  `json:"FileSizeRaw"`
-	FileHash            string `json:"FileHash"`
-	SourceFileHash      string `json:"SourceFileHash"`
-	DirectoryHash       string `json:"DirectoryHash"`
-	FileContent         []byte `json:"FileContent,omitempty"`
-	FileInodeChangeDate string `json:"FileInodeChangeDate"`
-	FileSize            string `json:"FileSize"`
-	FileType            string `json:"FileType"`
-	MIMEType            string `json:"MIMEType"`
-	WordCount           int    `json:"WordCount"`
-	FilePermissions     string `json:"FilePermissions"`
-	LineCount           int    `json:"LineCount"`
-	MIMEEncoding        string `json:"MIMEEncoding"`
-	ExifToolVersion     string `json:"ExifToolVersion"`
-	FileAccessDate      string `json:"FileAccessDate"`
-	FileName            string `json:"FileName"`
-	FileTypeExtension   string `json:"FileTypeExtension"`
-	SourceFile          string `json:"SourceFile"`
-	Directory           string `json:"Directory"`
-	FileModifyDate      string `json:"FileModifyDate"`
-	Newlines            string `json:"Newlines"`
-}
-
-type DirectoryData struct {
-	IsDirectory       bool        `json:"IsDirectory"`
-	SourceFile        string      `json:"SourceFile"`
-	SourceFileHash    string      `json:"SourceFileHash"`
-	Directory         string      `json:"Directory"`
-	DirectoryHash     string      `json:"DirectoryHash"`
-	FileModifyDate    time.Time   `json:"FileModifyDate"`
-	FilePermissions   os.FileMode `json:"FilePermissions"`
-	FileSizeRaw       int64       `json:"FileSizeRaw"`
-	FileTypeExtension string      `json:"FileTypeExtension"`
-	IsRoot            bool        `json:"IsRoot"`
-	RootDirectoryName string      `json:"RootDirectoryName"`
-	TotalSize         int64       `json:"TotalSize"`
-	Directories       int         `json:"Directories"`
-	Files             int         `json:"Files"`
-}
-
-func connectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName, collectionName string) (*mongo.Client, *mongo.Collection, context.Context, error) {
+// Connect to MongoDB and return the client and collection
+func ConnectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName, collectionName string) (*mongo.Client, *mongo.Collection, error) {
 	// Construct MongoDB connection URI
 	mongodbURI := dbType + "://" + dbUser + ":" + dbPwd + "@" + host + ":" + port
 
@@ -62,46 +19,73 @@ func connectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName, collectionName
 	// Connect to MongoDB
 	client, err := mongo.Connect(context.Background(), clientOptions)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
+		return nil, nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
 	}
 
 	// Check if the connection was successful
 	err = client.Ping(context.Background(), nil)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to ping MongoDB: %v", err)
+		return nil, nil, fmt.Errorf("failed to ping MongoDB: %v", err)
 	}
 
 	// Access the specified database and collection
 	db := client.Database(dbName)
 	collection := db.Collection(collectionName)
 
-	// Create a context with a 15-second timeout
-	ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
-
-	return client, collection, ctx, nil
+	return client, collection, nil
 }
 
-func insertFileDataIntoMongoDB(data FileData, collection *mongo.Collection, ctx context.Context) error {
-	insertResult, err := collection.InsertOne(ctx, data)
+// Upsert a FileRecord, DirectoryRecord or SymlinkRecord by its _id
+func UpsertRecord(ctx context.Context, collection *mongo.Collection, record interface{}) error {
+	doc, err := ToDocument(record)
 	if err != nil {
-		return fmt.Errorf("failed to insert file data into MongoDB FILEEE: %v", err)
+		return fmt.Errorf("failed to marshal record: %v", err)
 	}
+	return UpsertDocument(ctx, collection, doc)
+}
 
-	fmt.Println("Inserted document ID:", insertResult.InsertedID)
+// Upsert a Document by its _id, leaving fields it doesn't mention untouched
+func UpsertDocument(ctx context.Context, collection *mongo.Collection, doc Document) error {
+	filter := Document{"_id": doc.ID()}
+	update := Document{"$set": doc}
+	opts := options.Update().SetUpsert(true)
 
+	_, err := collection.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return fmt.Errorf("failed to upsert %s into MongoDB: %v", doc.ID(), err)
+	}
 	return nil
 }
 
-func insertDirectoryDataIntoMongoDB(data DirectoryData, collection *mongo.Collection, ctx context.Context) error {
+// Build the upsert for a file version, keeping FirstSeenTime from the first
+// time the version was recorded
+func FileVersionModel(version FileVersionRecord) *mongo.UpdateOneModel {
+	update := Document{
+		"$set": Document{
+			"SourceFile":     version.SourceFile,
+			"SourcePathHash": version.SourcePathHash,
+			"FileHash":       version.FileHash,
+			"FileHashAlgo":   version.FileHashAlgo,
+			"FileSizeRaw":    version.FileSizeRaw,
+			"FileModTime":    version.FileModTime,
+			"LastSeenTime":   version.LastSeenTime,
+		},
+		"$setOnInsert": Document{"FirstSeenTime": version.FirstSeenTime},
+	}
+	return mongo.NewUpdateOneModel().
+		SetFilter(Document{"_id": version.ID}).
+		SetUpdate(update).
+		SetUpsert(true)
+}
 
-	insertResult, err := collection.InsertOne(ctx, data)
-	fmt.Println("Inserted document ID:", insertResult)
+// Upsert a file version into the versions collection
+func UpsertFileVersion(ctx context.Context, collection *mongo.Collection, version FileVersionRecord) error {
+	model := FileVersionModel(version)
+	opts := options.Update().SetUpsert(true)
 
+	_, err := collection.UpdateOne(ctx, model.Filter, model.Update, opts)
 	if err != nil {
-		return fmt.Errorf("failed to insert directory data into MongoDB DIRECTORYYYYYY: %v", err)
+		return fmt.Errorf("failed to upsert version %s into MongoDB: %v", version.ID, err)
 	}
-
-	fmt.Println("Inserted document ID:", insertResult.InsertedID)
-
 	return nil
 }
@@ -0,0 +1,188 @@
+package mongoWrite
+
+import (
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BaseRecord holds the fields every index document has, whatever it
+// describes. Sizes are int64, flags are bool and timestamps are BSON dates.
+type BaseRecord struct {
+	ID                 string    `bson:"_id" json:"_id"`
+	SourceFile         string    `bson:"SourceFile" json:"SourceFile"`
+	DirectoryName      string    `bson:"DirectoryName" json:"DirectoryName"`
+	FileName           string    `bson:"FileName" json:"FileName"`
+	FileSizeRaw        int64     `bson:"FileSizeRaw" json:"FileSizeRaw"`
+	FileMode           string    `bson:"FileMode" json:"FileMode"`
+	FileModTime        time.Time `bson:"FileModTime" json:"FileModTime"`
+	SourcePathHash     string    `bson:"SourcePathHash" json:"SourcePathHash"`
+	DirectoryHash      string    `bson:"DirectoryHash" json:"DirectoryHash"`
+	AncestryPaths      []string  `bson:"AncestryPaths" json:"AncestryPaths"`
+	AncestryPathHashes []string  `bson:"AncestryPathHashes" json:"AncestryPathHashes"`
+	IndexTime          time.Time `bson:"IndexTime" json:"IndexTime"`
+	LastSeenTime       time.Time `bson:"LastSeenTime" json:"LastSeenTime"`
+	RunID              string    `bson:"RunID,omitempty" json:"RunID,omitempty"`
+	Deleted            bool      `bson:"Deleted" json:"Deleted"`
+	IsDirectory        bool      `bson:"IsDirectory" json:"IsDirectory"`
+	IsSymLink          bool      `bson:"IsSymLink" json:"IsSymLink"`
+}
+
+// FileRecord describes a regular file. MIMEType and FileCategory come from
+// the file's content; whatever exiftool reports is kept as-is in the Exif
+// sub-document, which is always written, as null when there is none, so
+// metadata a file lost or NoExif now skips doesn't linger. FileHashAlgo names
+// the algorithm of FileHash; documents without it were hashed with sha1. When
+// the file couldn't be hashed FileHash is empty and HashError says why; it is
+// always written so a later successful hash clears it. Large files may only
+// have a FileQuickHash fingerprint at first, with FileHashPending set until a
+// deep hash pass fills in FileHash.
+type FileRecord struct {
+	BaseRecord        `bson:",inline"`
+	FileHash          string                 `bson:"FileHash" json:"FileHash"`
+	FileHashAlgo      string                 `bson:"FileHashAlgo" json:"FileHashAlgo"`
+	FileQuickHash     string                 `bson:"FileQuickHash" json:"FileQuickHash"`
+	FileHashPending   bool                   `bson:"FileHashPending" json:"FileHashPending"`
+	HashError         string                 `bson:"HashError" json:"HashError"`
+	FileTypeExtension string                 `bson:"FileTypeExtension" json:"FileTypeExtension"`
+	MIMEType          string                 `bson:"MIMEType" json:"MIMEType"`
+	FileCategory      string                 `bson:"FileCategory" json:"FileCategory"`
+	Exif              map[string]interface{} `bson:"Exif" json:"Exif"`
+}
+
+// DirectoryRecord describes a directory and the files and directories
+// beneath it
+type DirectoryRecord struct {
+	BaseRecord               `bson:",inline"`
+	ChildDirectoryCount      int64 `bson:"ChildDirectoryCount" json:"ChildDirectoryCount"`
+	ChildFileCount           int64 `bson:"ChildFileCount" json:"ChildFileCount"`
+	ChildSizeRaw             int64 `bson:"ChildSizeRaw" json:"ChildSizeRaw"`
+	DescendentDirectoryCount int64 `bson:"DescendentDirectoryCount" json:"DescendentDirectoryCount"`
+	DescendentFileCount      int64 `bson:"DescendentFileCount" json:"DescendentFileCount"`
+	DescendentSizeRaw        int64 `bson:"DescendentSizeRaw" json:"DescendentSizeRaw"`
+}
+
+// SymlinkRecord describes a symbolic link that was not followed
+type SymlinkRecord struct {
+	BaseRecord `bson:",inline"`
+	LinkFields `bson:",inline"`
+}
+
+// LinkFields describe how a symbolic link resolves: SymlinkChain is the link,
+// each link after it and the path the last one leads to, and SymlinkTarget
+// that path with every link resolved. A broken link has no target and
+// SymlinkBroken set; one that leads back to itself or to a directory it is
+// in has SymlinkLoop set. A followed link is indexed as the file or
+// directory it leads to, with IsSymLink and these fields alongside.
+type LinkFields struct {
+	SymlinkDestination string   `bson:"SymlinkDestination" json:"SymlinkDestination"`
+	SymlinkChain       []string `bson:"SymlinkChain" json:"SymlinkChain"`
+	SymlinkTarget      string   `bson:"SymlinkTarget" json:"SymlinkTarget"`
+	SymlinkBroken      bool     `bson:"SymlinkBroken" json:"SymlinkBroken"`
+	SymlinkLoop        bool     `bson:"SymlinkLoop" json:"SymlinkLoop"`
+	SymlinkFollowed    bool     `bson:"SymlinkFollowed" json:"SymlinkFollowed"`
+}
+
+// FileVersionRecord records one distinct content hash seen at a path.
+// FirstSeenTime is only set when the version is first recorded; every later
+// sighting moves LastSeenTime forward.
+type FileVersionRecord struct {
+	ID             string    `bson:"_id" json:"_id"`
+	SourceFile     string    `bson:"SourceFile" json:"SourceFile"`
+	SourcePathHash string    `bson:"SourcePathHash" json:"SourcePathHash"`
+	FileHash       string    `bson:"FileHash" json:"FileHash"`
+	FileHashAlgo   string    `bson:"FileHashAlgo" json:"FileHashAlgo"`
+	FileSizeRaw    int64     `bson:"FileSizeRaw" json:"FileSizeRaw"`
+	FileModTime    time.Time `bson:"FileModTime" json:"FileModTime"`
+	FirstSeenTime  time.Time `bson:"FirstSeenTime" json:"FirstSeenTime"`
+	LastSeenTime   time.Time `bson:"LastSeenTime" json:"LastSeenTime"`
+}
+
+// TreeNodeRecord is one directory in the tree collection, keyed by its path
+// hash. Following ParentHash walks up the hierarchy; Depth counts levels
+// below the scanned root, which has depth 0.
+type TreeNodeRecord struct {
+	ID                       string    `bson:"_id" json:"_id"`
+	SourceFile               string    `bson:"SourceFile" json:"SourceFile"`
+	FileName                 string    `bson:"FileName" json:"FileName"`
+	ParentHash               string    `bson:"ParentHash" json:"ParentHash"`
+	AncestryPathHashes       []string  `bson:"AncestryPathHashes" json:"AncestryPathHashes"`
+	Depth                    int64     `bson:"Depth" json:"Depth"`
+	ChildDirectoryCount      int64     `bson:"ChildDirectoryCount" json:"ChildDirectoryCount"`
+	ChildFileCount           int64     `bson:"ChildFileCount" json:"ChildFileCount"`
+	ChildSizeRaw             int64     `bson:"ChildSizeRaw" json:"ChildSizeRaw"`
+	DescendentDirectoryCount int64     `bson:"DescendentDirectoryCount" json:"DescendentDirectoryCount"`
+	DescendentFileCount      int64     `bson:"DescendentFileCount" json:"DescendentFileCount"`
+	DescendentSizeRaw        int64     `bson:"DescendentSizeRaw" json:"DescendentSizeRaw"`
+	IndexTime                time.Time `bson:"IndexTime" json:"IndexTime"`
+	RunID                    string    `bson:"RunID,omitempty" json:"RunID,omitempty"`
+}
+
+// Document is an index document as stored: field names mapped to native
+// BSON values. Records become Documents before they are handed to a sink,
+// which lets sinks merge partial updates the way a MongoDB $set does.
+type Document map[string]interface{}
+
+// ToDocument converts a record into a Document with the same BSON types
+// MongoDB would store for it
+func ToDocument(record interface{}) (Document, error) {
+	data, err := bson.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// ID returns the document's _id
+func (d Document) ID() string {
+	id, _ := d["_id"].(string)
+	return id
+}
+
+// Int64 returns a numeric field as an int64, or 0 if it is missing.
+// Documents from before typed records store numbers as strings.
+func (d Document) Int64(key string) int64 {
+	switch value := d[key].(type) {
+	case int64:
+		return value
+	case int32:
+		return int64(value)
+	case int:
+		return int64(value)
+	case float64:
+		return int64(value)
+	case string:
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// Bool returns a boolean field, or false if it is missing. Documents from
+// before typed records store flags as "true" and "false".
+func (d Document) Bool(key string) bool {
+	switch value := d[key].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// Time returns a date field, or the zero time if it is missing
+func (d Document) Time(key string) time.Time {
+	switch value := d[key].(type) {
+	case primitive.DateTime:
+		return value.Time()
+	case time.Time:
+		return value
+	}
+	return time.Time{}
+}
@@ -0,0 +1,3 @@
+module RSKGroup/OPIe/utils/pathFilter
+
+go 1.20
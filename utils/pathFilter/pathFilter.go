@@ -0,0 +1,396 @@
+package pathFilter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DefaultIgnoreFile is the per-directory ignore file read when IgnoreFile is
+// not set
+const DefaultIgnoreFile = ".opieignore"
+
+// Patterns starting with this are regular expressions rather than globs
+const regexpPrefix = "re:"
+
+// Config holds the filter settings read from conf.json. Patterns use
+// gitignore syntax, or are regular expressions when prefixed with "re:", and
+// are matched against paths relative to the root.
+type Config struct {
+	// Only files matching one of these, or inside a directory matching one,
+	// are kept; empty keeps everything. Directories are always descended.
+	Include []string `json:"Include"`
+	// Files and directories matching these are skipped, directories with
+	// everything below them. A later "!pattern" brings a path back.
+	Exclude []string `json:"Exclude"`
+	// Deepest level below the root that is kept; 0 for no limit
+	MaxDepth int `json:"MaxDepth"`
+	// Bounds on the size of regular files in bytes; 0 for no bound
+	MinSize int64 `json:"MinSize"`
+	MaxSize int64 `json:"MaxSize"`
+	// Skip files and directories whose names start with a dot
+	SkipHidden bool `json:"SkipHidden"`
+	// Name of the per-directory ignore file, whose patterns apply below the
+	// directory it is in and override Exclude
+	IgnoreFile string `json:"IgnoreFile"`
+}
+
+// pattern is one compiled gitignore-style pattern or regular expression
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Filter decides which paths below a root are scanned or watched. It is safe
+// for concurrent use.
+type Filter struct {
+	root       string
+	config     Config
+	ignoreFile string
+	include    []pattern
+	exclude    []pattern
+
+	// Called with ignore files that can't be read, which are treated as
+	// empty, and with each of their lines that can't be parsed, which are
+	// skipped
+	OnError func(path string, err error)
+
+	mu      sync.Mutex
+	ignored map[string][]pattern
+}
+
+// New compiles a filter for the paths below root
+func New(root string, config Config) (*Filter, error) {
+	f := &Filter{
+		root:       filepath.Clean(root),
+		config:     config,
+		ignoreFile: config.IgnoreFile,
+		ignored:    make(map[string][]pattern),
+	}
+	if f.ignoreFile == "" {
+		f.ignoreFile = DefaultIgnoreFile
+	}
+
+	var err error
+	if f.include, err = parsePatterns(config.Include); err != nil {
+		return nil, fmt.Errorf("invalid Include pattern: %v", err)
+	}
+	if f.exclude, err = parsePatterns(config.Exclude); err != nil {
+		return nil, fmt.Errorf("invalid Exclude pattern: %v", err)
+	}
+	return f, nil
+}
+
+// Root returns the directory the filter's patterns are relative to
+func (f *Filter) Root() string {
+	return f.root
+}
+
+// Allow reports whether an entry should be scanned, given that its parent
+// directory was allowed. info is the entry's Lstat result. The root itself
+// and paths outside it are always allowed.
+func (f *Filter) Allow(pathValue string, info os.FileInfo) bool {
+	rel, ok := f.relative(pathValue)
+	if !ok {
+		return true
+	}
+	return f.allow(rel, info)
+}
+
+// AllowPath reports whether a path should be scanned, checking each of the
+// directories between it and the root as well. It is for paths that turn up
+// on their own, like file system events. info may be nil for a path that no
+// longer exists, in which case it is judged as a file of unknown size.
+func (f *Filter) AllowPath(pathValue string, info os.FileInfo) bool {
+	rel, ok := f.relative(pathValue)
+	if !ok {
+		return true
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if !f.allowEntry(strings.Join(parts[:i], "/"), true, -1) {
+			return false
+		}
+	}
+	return f.allow(rel, info)
+}
+
+// Forget drops the cached ignore file of a directory, so it is read again
+// the next time it is needed
+func (f *Filter) Forget(dir string) {
+	f.mu.Lock()
+	delete(f.ignored, filepath.Clean(dir))
+	f.mu.Unlock()
+}
+
+// IsIgnoreFile reports whether a path is a per-directory ignore file
+func (f *Filter) IsIgnoreFile(pathValue string) bool {
+	return filepath.Base(pathValue) == f.ignoreFile
+}
+
+// The slash-separated path of pathValue relative to the root, or false if it
+// is the root or outside it
+func (f *Filter) relative(pathValue string) (string, bool) {
+	rel, err := filepath.Rel(f.root, filepath.Clean(pathValue))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+func (f *Filter) allow(rel string, info os.FileInfo) bool {
+	if info == nil {
+		return f.allowEntry(rel, false, -1)
+	}
+	size := int64(-1)
+	if info.Mode().IsRegular() {
+		size = info.Size()
+	}
+	return f.allowEntry(rel, info.IsDir(), size)
+}
+
+// Decide on one entry. size is -1 for anything but a regular file.
+func (f *Filter) allowEntry(rel string, isDir bool, size int64) bool {
+	if f.config.SkipHidden && strings.HasPrefix(path.Base(rel), ".") {
+		return false
+	}
+	if f.config.MaxDepth > 0 && strings.Count(rel, "/")+1 > f.config.MaxDepth {
+		return false
+	}
+	if f.excluded(rel, isDir) {
+		return false
+	}
+	if isDir {
+		return true
+	}
+	if size >= 0 {
+		if f.config.MinSize > 0 && size < f.config.MinSize {
+			return false
+		}
+		if f.config.MaxSize > 0 && size > f.config.MaxSize {
+			return false
+		}
+	}
+	return len(f.include) == 0 || f.included(rel)
+}
+
+// Whether the ignore files from the entry's directory up to the root, and
+// then Exclude, skip it. The nearest pattern that matches decides.
+func (f *Filter) excluded(rel string, isDir bool) bool {
+	dir := path.Dir(rel)
+	for {
+		rules := f.ignoreRules(dir)
+		if len(rules) > 0 {
+			local := rel
+			if dir != "." {
+				local = strings.TrimPrefix(rel, dir+"/")
+			}
+			if ignored, ok := match(rules, local, isDir); ok {
+				return ignored
+			}
+		}
+		if dir == "." {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+	ignored, _ := match(f.exclude, rel, isDir)
+	return ignored
+}
+
+// Whether a file, or one of the directories it is in, matches Include
+func (f *Filter) included(rel string) bool {
+	if included, ok := match(f.include, rel, false); ok {
+		return included
+	}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if included, ok := match(f.include, dir, true); ok {
+			return included
+		}
+	}
+	return false
+}
+
+// The patterns of a directory's ignore file, given relative to the root
+func (f *Filter) ignoreRules(dir string) []pattern {
+	dirPath := filepath.Join(f.root, filepath.FromSlash(dir))
+	f.mu.Lock()
+	rules, ok := f.ignored[dirPath]
+	f.mu.Unlock()
+	if ok {
+		return rules
+	}
+
+	ignorePath := filepath.Join(dirPath, f.ignoreFile)
+	rules, errs := readPatterns(ignorePath)
+	if f.OnError != nil {
+		for _, err := range errs {
+			f.OnError(ignorePath, err)
+		}
+	}
+	f.mu.Lock()
+	f.ignored[dirPath] = rules
+	f.mu.Unlock()
+	return rules
+}
+
+// Read an ignore file; a missing one has no patterns. Lines that can't be
+// parsed are skipped, and returned as errors with the rest.
+func readPatterns(filename string) ([]pattern, []error) {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer file.Close()
+
+	var patterns []pattern
+	var errs []error
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		p, ok, err := parsePattern(scanner.Text())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %q: %v", n, scanner.Text(), err))
+		} else if ok {
+			patterns = append(patterns, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, append(errs, err)
+	}
+	return patterns, errs
+}
+
+// Compile gitignore-style patterns, skipping blank lines and comments
+func parsePatterns(lines []string) ([]pattern, error) {
+	var patterns []pattern
+	for _, line := range lines {
+		p, ok, err := parsePattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", line, err)
+		}
+		if ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns, nil
+}
+
+// Compile one pattern. A leading "!" negates it and a trailing "/" limits it
+// to directories. A pattern with a "/" anywhere else is anchored to the
+// directory it is relative to; one without matches a name at any depth.
+// After "re:" comes a regular expression, searched for in the whole
+// relative path of files and directories alike.
+func parsePattern(line string) (pattern, bool, error) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false, nil
+	}
+
+	var p pattern
+	switch {
+	case strings.HasPrefix(line, "!"):
+		p.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\#`), strings.HasPrefix(line, `\!`):
+		line = line[1:]
+	}
+	if strings.HasPrefix(line, regexpPrefix) {
+		re, err := regexp.Compile(line[len(regexpPrefix):])
+		if err != nil {
+			return pattern{}, false, err
+		}
+		p.re = re
+		return p, true, nil
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return pattern{}, false, nil
+	}
+
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return pattern{}, false, err
+	}
+	p.re = re
+	return p, true, nil
+}
+
+// Translate a glob to a regular expression. "*" and "?" stay within one path
+// element; "**/" matches any number of directories and "**" anything.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				i++
+				if atStart && i+1 < len(glob) && glob[i+1] == '/' {
+					b.WriteString("(?:.*/)?")
+					i++
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// The verdict of the last pattern matching a path, and whether any did
+func match(patterns []pattern, rel string, isDir bool) (bool, bool) {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		p := patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			return !p.negate, true
+		}
+	}
+	return false, false
+}
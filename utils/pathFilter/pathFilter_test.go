@@ -0,0 +1,207 @@
+package pathFilter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		rel      string
+		isDir    bool
+		want     bool // whether the last matching pattern includes the path
+		matched  bool // whether any pattern matched
+	}{
+		// Patterns without a slash match a name at any depth
+		{"name at root", []string{"*.log"}, "app.log", false, true, true},
+		{"name below", []string{"*.log"}, "a/b/app.log", false, true, true},
+		{"star within element", []string{"*.log"}, "a.log/b", false, false, false},
+		{"question mark", []string{"?.txt"}, "a/b.txt", false, true, true},
+		{"class", []string{"[ab].txt"}, "b.txt", false, true, true},
+		{"negated class", []string{"[!ab].txt"}, "b.txt", false, false, false},
+
+		// A slash anywhere but at the end anchors the pattern
+		{"anchored", []string{"/build"}, "build", true, true, true},
+		{"anchored not below", []string{"/build"}, "src/build", true, false, false},
+		{"anchored with slash", []string{"src/*.go"}, "src/main.go", false, true, true},
+		{"anchored with slash not below", []string{"src/*.go"}, "lib/src/main.go", false, false, false},
+		{"anchored star stays in element", []string{"src/*.go"}, "src/a/main.go", false, false, false},
+
+		// "**"
+		{"leading double star", []string{"**/cache"}, "a/b/cache", true, true, true},
+		{"leading double star at root", []string{"**/cache"}, "cache", true, true, true},
+		{"middle double star", []string{"a/**/z"}, "a/b/c/z", false, true, true},
+		{"middle double star none", []string{"a/**/z"}, "a/z", false, true, true},
+		{"trailing double star", []string{"a/**"}, "a/b/c", false, true, true},
+
+		// A trailing slash only matches directories
+		{"dir only dir", []string{"node_modules/"}, "x/node_modules", true, true, true},
+		{"dir only file", []string{"node_modules/"}, "x/node_modules", false, false, false},
+
+		// A later "!" brings a path back, and the last match decides
+		{"negation", []string{"*.log", "!keep.log"}, "keep.log", false, false, true},
+		{"negation other", []string{"*.log", "!keep.log"}, "other.log", false, true, true},
+		{"negation overridden", []string{"!keep.log", "*.log"}, "keep.log", false, true, true},
+
+		// Escapes
+		{"escaped hash", []string{`\#notes`}, "#notes", false, true, true},
+		{"escaped bang", []string{`\!important`}, "!important", false, true, true},
+		{"comment", []string{"# *.go"}, "main.go", false, false, false},
+
+		// Regular expressions search the whole relative path
+		{"regexp", []string{`re:\.(tmp|bak)$`}, "a/b.bak", false, true, true},
+		{"regexp no match", []string{`re:\.(tmp|bak)$`}, "a/b.bakx", false, false, false},
+		{"regexp anchored", []string{`re:^src/.*_test\.go$`}, "src/a/x_test.go", false, true, true},
+		{"regexp anchored not below", []string{`re:^src/.*_test\.go$`}, "lib/src/x_test.go", false, false, false},
+		{"regexp directory", []string{`re:(^|/)build\d+$`}, "out/build42", true, true, true},
+		{"regexp negated", []string{"*.go", `!re:_test\.go$`}, "x_test.go", false, false, true},
+		{"escaped regexp prefix", []string{`\re:x`}, "re:x", false, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns, err := parsePatterns(tt.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, matched := match(patterns, tt.rel, tt.isDir)
+			if have != tt.want || matched != tt.matched {
+				t.Errorf("match(%q, %q, %t): have %t, %t, want %t, %t",
+					tt.patterns, tt.rel, tt.isDir, have, matched, tt.want, tt.matched)
+			}
+		})
+	}
+}
+
+func TestParsePatternsInvalid(t *testing.T) {
+	for _, line := range []string{"[]]", "[z-a]", "re:(", "!re:a[b"} {
+		if _, err := parsePatterns([]string{line}); err == nil {
+			t.Errorf("no error for %q", line)
+		}
+	}
+}
+
+// A bad line in an ignore file should be skipped and reported, and the rest
+// of the file still apply
+func TestIgnoreFileBadLine(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, DefaultIgnoreFile), "*.tmp\n[]]\nre:(\nbuild/\n")
+
+	f, err := New(root, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	f.OnError = func(path string, err error) {
+		if path != filepath.Join(root, DefaultIgnoreFile) {
+			t.Errorf("error for %s", path)
+		}
+		errs = append(errs, err)
+	}
+
+	if f.AllowPath(filepath.Join(root, "a.tmp"), nil) {
+		t.Error("a.tmp allowed")
+	}
+	if f.AllowPath(filepath.Join(root, "build", "x"), nil) {
+		t.Error("build/x allowed")
+	}
+	if !f.AllowPath(filepath.Join(root, "a.txt"), nil) {
+		t.Error("a.txt not allowed")
+	}
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "line 2") || !strings.Contains(errs[1].Error(), "line 3") {
+		t.Errorf("errors: %v", errs)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "sub", DefaultIgnoreFile), "*.bin\n!keep.bin\n/local/\n")
+
+	f, err := New(root, Config{
+		Exclude:  []string{".git/", "*.log", "/top.txt", `re:(^|/)~[^/]*$`},
+		Include:  []string{"*.txt", "*.bin", "docs/"},
+		MaxDepth: 3,
+		MaxSize:  100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.OnError = func(path string, err error) { t.Errorf("%s: %v", path, err) }
+
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"a.txt", false, true},
+		{"a.go", false, false},        // not included
+		{"docs/a.go", false, true},    // in an included directory
+		{"app.log", false, false},     // excluded
+		{"top.txt", false, false},     // anchored exclude
+		{"sub/top.txt", false, true},  // not the anchored one
+		{".git", true, false},         // excluded directory
+		{".git/a.txt", false, false},  // below an excluded directory
+		{"src", true, true},           // directories are always descended
+		{"a/~a.txt", false, false},    // regular expression
+		{"sub/a.bin", false, false},   // ignore file
+		{"sub/keep.bin", false, true}, // brought back by the ignore file
+		{"sub/x/a.bin", false, false}, // ignore file applies below its directory
+		{"sub/local", true, false},    // anchored to the ignore file's directory
+		{"local", true, true},         // not the ignore file's
+		{"a/b/c/d.txt", false, false}, // deeper than MaxDepth
+		{"a/b/c.txt", false, true},    // at MaxDepth
+	}
+	for _, tt := range tests {
+		if have := f.AllowPath(filepath.Join(root, filepath.FromSlash(tt.rel)), fakeInfo(tt.isDir, 10)); have != tt.want {
+			t.Errorf("AllowPath(%q): have %t, want %t", tt.rel, have, tt.want)
+		}
+	}
+
+	if f.Allow(filepath.Join(root, "big.txt"), fakeInfo(false, 101)) {
+		t.Error("file above MaxSize allowed")
+	}
+	if !f.Allow(root, fakeInfo(true, 0)) {
+		t.Error("root not allowed")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New(t.TempDir(), Config{Exclude: []string{"re:("}})
+	if err == nil {
+		t.Error("no error for an invalid Exclude pattern")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeFileInfo is the FileInfo of a regular file of the given size, or of
+// a directory
+type fakeFileInfo struct {
+	os.FileInfo
+	isDir bool
+	size  int64
+}
+
+func fakeInfo(isDir bool, size int64) os.FileInfo {
+	return fakeFileInfo{isDir: isDir, size: size}
+}
+
+func (i fakeFileInfo) IsDir() bool { return i.isDir }
+func (i fakeFileInfo) Size() int64 { return i.size }
+func (i fakeFileInfo) Mode() os.FileMode {
+	if i.isDir {
+		return os.ModeDir
+	}
+	return 0
+}
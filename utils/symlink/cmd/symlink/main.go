@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"RSKGroup/OPIe/utils/symlink"
+)
+
+var path *string
+
+func init() {
+	path = flag.String("path", "/home/delimp/Downloads/OPIe", "full path")
+}
+
+func main() {
+	flag.Parse()
+	filePath := *path
+
+	// Get file information using Lstat
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		fmt.Println("Failed to get file information: ", err)
+		return
+	}
+
+	// Check if the file is a symlink
+	if info.Mode()&os.ModeSymlink != 0 {
+		fmt.Printf("%s is a symbolic link\n", filePath)
+
+		// Follow the chain of links to where it ends
+		link, err := symlink.Resolve(filePath)
+		if err != nil {
+			fmt.Println("Failed to resolve symlink: ", err)
+			return
+		}
+		fmt.Printf("Symlink destination: %s\n", link.Destination)
+		for i, hop := range link.Chain[1:] {
+			fmt.Printf("  %d -> %s\n", i+1, hop)
+		}
+		switch {
+		case link.Broken:
+			fmt.Println("Symlink is broken")
+		case link.Loop:
+			fmt.Println("Symlink loops back on itself")
+		default:
+			fmt.Printf("Symlink target: %s\n", link.Target)
+		}
+	} else {
+		fmt.Printf("%s is not a symbolic link\n", filePath)
+	}
+}
@@ -1,3 +1,3 @@
-module symlink
+module RSKGroup/OPIe/utils/symlink
 
 go 1.20
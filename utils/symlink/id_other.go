@@ -0,0 +1,18 @@
+//go:build !unix
+
+package symlink
+
+import "os"
+
+// FileID identifies a file by the device it is on and its inode, whatever
+// path it is reached by
+type FileID struct {
+	Device uint64
+	Inode  uint64
+}
+
+// ID returns the identity of the file an os.FileInfo describes. This
+// platform doesn't report one, so loops are only caught by their paths.
+func ID(info os.FileInfo) (FileID, bool) {
+	return FileID{}, false
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package symlink
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileID identifies a file by the device it is on and its inode, whatever
+// path it is reached by
+type FileID struct {
+	Device uint64
+	Inode  uint64
+}
+
+// ID returns the identity of the file an os.FileInfo describes, if the
+// platform reports one
+func ID(info os.FileInfo) (FileID, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, false
+	}
+	return FileID{Device: uint64(stat.Dev), Inode: uint64(stat.Ino)}, true
+}
@@ -1,41 +1,99 @@
-package main
+package symlink
 
 import (
-        "flag"
-	"fmt"
+	"errors"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 )
 
-var path *string
+// Links followed before a chain is given up as a loop, as the kernel does
+const maxLinks = 40
 
-func init() {
-	path = flag.String("path", "/home/delimp/Downloads/OPIe", "full path")
+// Link describes where a symbolic link leads
+type Link struct {
+	// What the link itself holds, as os.Readlink returns it
+	Destination string
+	// The link, every link after it and the path the last one leads to
+	Chain []string
+	// The path the chain ends at with every link in it resolved; empty when
+	// the link is broken or loops
+	Target string
+	// The chain ends at a path that doesn't exist
+	Broken bool
+	// The chain leads back to itself
+	Loop bool
 }
 
-func main() {
-        flag.Parse()
-        filePath := *path
-
-	// Get file information using Lstat
-	info, err := os.Lstat(filePath)
+// Resolve follows a symbolic link to the end of its chain. A broken or
+// looping chain is not an error; it is reported in the Link.
+func Resolve(path string) (Link, error) {
+	destination, err := os.Readlink(path)
 	if err != nil {
-		fmt.Println("Failed to get file information: ", err)
-		return
+		return Link{}, err
 	}
+	link := Link{Destination: destination, Chain: []string{path}}
 
-	// Check if the file is a symlink
-	if info.Mode()&os.ModeSymlink != 0 {
-		fmt.Printf("%s is a symbolic link\n", filePath)
+	seen := map[string]bool{filepath.Clean(path): true}
+	current, next := path, destination
+	for {
+		if !filepath.IsAbs(next) {
+			next = filepath.Join(filepath.Dir(current), next)
+		}
+		next = filepath.Clean(next)
+		if seen[next] || len(link.Chain) > maxLinks {
+			link.Loop = true
+			return link, nil
+		}
+		seen[next] = true
+		link.Chain = append(link.Chain, next)
 
-		// Get the destination path of the symlink
-		destPath, err := os.Readlink(filePath)
+		info, err := os.Lstat(next)
+		if isMissing(err) {
+			link.Broken = true
+			return link, nil
+		}
 		if err != nil {
-			fmt.Println("Failed to get symlink destination: ", err)
-			return
+			return link, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			// Directories on the way may be links themselves
+			target, err := filepath.EvalSymlinks(next)
+			if isMissing(err) {
+				link.Broken = true
+				return link, nil
+			}
+			if errors.Is(err, syscall.ELOOP) {
+				link.Loop = true
+				return link, nil
+			}
+			if err != nil {
+				return link, err
+			}
+			link.Target = target
+			return link, nil
+		}
+
+		current = next
+		if next, err = os.Readlink(current); err != nil {
+			return link, err
 		}
-		fmt.Printf("Symlink destination: %s\n", destPath)
-	} else {
-		fmt.Printf("%s is not a symbolic link\n", filePath)
 	}
 }
 
+// Whether an error means a path leads nowhere
+func isMissing(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// Contains reports whether path is dir or somewhere below it. Both should be
+// clean and absolute.
+func Contains(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
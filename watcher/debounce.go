@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// debouncer holds back the changes to each path until they settle, so a
+// burst of events, like the dozens of Writes of saving a large file, is
+// applied once. A path's change is passed on once no event has arrived for
+// it for the quiet period, or once it has been held for the max wait
+// however busy it is. The changes held meanwhile are coalesced into one.
+//
+// At most maxPending paths are held; past that the longest held is passed
+// on early to make room, so a flood of events costs precision rather than
+// memory. It is only used from the event loop.
+type debouncer struct {
+	quiet      time.Duration
+	maxWait    time.Duration
+	maxPending int
+	emit       func(change)
+	// The clock, replaced in tests
+	now func() time.Time
+
+	pending map[string]*list.Element
+	// Held changes, longest held first
+	order *list.List
+}
+
+// heldChange is a change being held back, and when it started and was last
+// added to
+type heldChange struct {
+	change
+	first time.Time
+	last  time.Time
+}
+
+func newDebouncer(quiet, maxWait time.Duration, maxPending int, emit func(change)) *debouncer {
+	return &debouncer{
+		quiet:      quiet,
+		maxWait:    maxWait,
+		maxPending: maxPending,
+		emit:       emit,
+		now:        time.Now,
+		pending:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// add holds back a change, coalescing it with the one already held for its
+// path. A move is passed on at once; what is held under its old path is
+// carried over to the new one, to be applied after the move.
+func (d *debouncer) add(c change) {
+	now := d.now()
+	if c.op == fsnotify.Rename {
+		d.moved(c.from, c.path)
+		d.emit(c)
+		return
+	}
+
+	if element, ok := d.pending[c.path]; ok {
+		held := element.Value.(*heldChange)
+		held.op = coalesce(held.op, c.op)
+		held.last = now
+		return
+	}
+
+	if len(d.pending) >= d.maxPending {
+		d.pass(d.order.Front())
+	}
+	d.pending[c.path] = d.order.PushBack(&heldChange{change: c, first: now, last: now})
+}
+
+// coalesce combines the op held for a path with a later one. A removal
+// overrides whatever came before it, and anything after a removal means the
+// path is back. Content changes outrank a Chmod, which on its own only needs
+// the file mode refreshed.
+func coalesce(held, later fsnotify.Op) fsnotify.Op {
+	if later == fsnotify.Remove {
+		return fsnotify.Remove
+	}
+	if held == fsnotify.Remove {
+		return fsnotify.Create
+	}
+	if held|later == fsnotify.Chmod {
+		return fsnotify.Chmod
+	}
+	if (held|later)&fsnotify.Create == fsnotify.Create {
+		return fsnotify.Create
+	}
+	return fsnotify.Write
+}
+
+// moved carries the changes held below a renamed path over to its new name
+func (d *debouncer) moved(oldPath, newPath string) {
+	var carried []*heldChange
+	for path, element := range d.pending {
+		if isUnder(path, oldPath) {
+			carried = append(carried, element.Value.(*heldChange))
+			d.order.Remove(element)
+			delete(d.pending, path)
+		}
+	}
+	for _, held := range carried {
+		held.path = newPath + held.path[len(oldPath):]
+		if element, ok := d.pending[held.path]; ok {
+			other := element.Value.(*heldChange)
+			other.op = coalesce(other.op, held.op)
+			continue
+		}
+		d.pending[held.path] = d.order.PushBack(held)
+	}
+}
+
+// settle passes on every change that has been quiet for the quiet period or
+// held for the max wait
+func (d *debouncer) settle() {
+	now := d.now()
+	for element := d.order.Front(); element != nil; {
+		next := element.Next()
+		held := element.Value.(*heldChange)
+		if now.Sub(held.last) >= d.quiet || now.Sub(held.first) >= d.maxWait {
+			d.pass(element)
+		}
+		element = next
+	}
+}
+
+// flush passes on every change held
+func (d *debouncer) flush() {
+	for d.order.Len() > 0 {
+		d.pass(d.order.Front())
+	}
+}
+
+func (d *debouncer) pass(element *list.Element) {
+	held := d.order.Remove(element).(*heldChange)
+	delete(d.pending, held.path)
+	d.emit(held.change)
+}
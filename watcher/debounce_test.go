@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestCoalesce(t *testing.T) {
+	tests := []struct {
+		held, later, want fsnotify.Op
+	}{
+		{fsnotify.Write, fsnotify.Write, fsnotify.Write},
+		{fsnotify.Create, fsnotify.Write, fsnotify.Create},
+		{fsnotify.Write, fsnotify.Create, fsnotify.Create},
+		{fsnotify.Chmod, fsnotify.Chmod, fsnotify.Chmod},
+		{fsnotify.Chmod, fsnotify.Write, fsnotify.Write},
+		{fsnotify.Write, fsnotify.Chmod, fsnotify.Write},
+		{fsnotify.Create, fsnotify.Chmod, fsnotify.Create},
+		{fsnotify.Write, fsnotify.Remove, fsnotify.Remove},
+		{fsnotify.Create, fsnotify.Remove, fsnotify.Remove},
+		{fsnotify.Remove, fsnotify.Create, fsnotify.Create},
+		{fsnotify.Remove, fsnotify.Write, fsnotify.Create},
+		{fsnotify.Remove, fsnotify.Chmod, fsnotify.Create},
+		{fsnotify.Remove, fsnotify.Remove, fsnotify.Remove},
+	}
+	for _, tt := range tests {
+		if have := coalesce(tt.held, tt.later); have != tt.want {
+			t.Errorf("coalesce(%s, %s): have %s, want %s", tt.held, tt.later, have, tt.want)
+		}
+	}
+}
+
+// testDebouncer returns a debouncer on a fake clock, the changes it emitted
+// so far and a function advancing the clock
+func testDebouncer(maxPending int) (*debouncer, *[]change, func(time.Duration)) {
+	var emitted []change
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDebouncer(200*time.Millisecond, time.Second, maxPending, func(c change) {
+		emitted = append(emitted, c)
+	})
+	d.now = func() time.Time { return now }
+	return d, &emitted, func(by time.Duration) { now = now.Add(by) }
+}
+
+func checkEmitted(t *testing.T, have *[]change, want ...change) {
+	t.Helper()
+	if len(want) == 0 && len(*have) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(*have, want) {
+		t.Errorf("emitted:\nhave %v\nwant %v", *have, want)
+	}
+	*have = nil
+}
+
+func TestDebouncer(t *testing.T) {
+	t.Run("burst", func(t *testing.T) {
+		d, emitted, advance := testDebouncer(10)
+		d.add(change{path: "/w/a", op: fsnotify.Create})
+		for i := 0; i < 3; i++ {
+			advance(100 * time.Millisecond)
+			d.add(change{path: "/w/a", op: fsnotify.Write})
+			d.settle()
+		}
+		checkEmitted(t, emitted)
+		advance(200 * time.Millisecond)
+		d.settle()
+		checkEmitted(t, emitted, change{path: "/w/a", op: fsnotify.Create})
+	})
+
+	t.Run("max wait", func(t *testing.T) {
+		d, emitted, advance := testDebouncer(10)
+		for i := 0; i < 10; i++ {
+			d.add(change{path: "/w/a", op: fsnotify.Write})
+			d.settle()
+			checkEmitted(t, emitted)
+			advance(100 * time.Millisecond)
+		}
+		d.settle()
+		checkEmitted(t, emitted, change{path: "/w/a", op: fsnotify.Write})
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		d, emitted, _ := testDebouncer(10)
+		d.add(change{path: "/w/a", op: fsnotify.Create})
+		d.add(change{path: "/w/a", op: fsnotify.Write})
+		d.add(change{path: "/w/a", op: fsnotify.Remove})
+		d.add(change{path: "/w/b", op: fsnotify.Remove})
+		d.add(change{path: "/w/b", op: fsnotify.Create})
+		d.flush()
+		checkEmitted(t, emitted,
+			change{path: "/w/a", op: fsnotify.Remove},
+			change{path: "/w/b", op: fsnotify.Create})
+	})
+
+	t.Run("max pending", func(t *testing.T) {
+		d, emitted, _ := testDebouncer(2)
+		d.add(change{path: "/w/a", op: fsnotify.Write})
+		d.add(change{path: "/w/b", op: fsnotify.Write})
+		d.add(change{path: "/w/a", op: fsnotify.Write})
+		checkEmitted(t, emitted)
+		d.add(change{path: "/w/c", op: fsnotify.Write})
+		checkEmitted(t, emitted, change{path: "/w/a", op: fsnotify.Write})
+		d.flush()
+		checkEmitted(t, emitted,
+			change{path: "/w/b", op: fsnotify.Write},
+			change{path: "/w/c", op: fsnotify.Write})
+	})
+
+	t.Run("moved", func(t *testing.T) {
+		d, emitted, _ := testDebouncer(10)
+		d.add(change{path: "/w/dir/a", op: fsnotify.Write})
+		d.add(change{path: "/w/dir/sub/b", op: fsnotify.Create})
+		d.add(change{path: "/w/dir2/c", op: fsnotify.Write})
+		d.add(change{path: "/w/new/a", op: fsnotify.Remove})
+		move := change{path: "/w/new", from: "/w/dir", op: fsnotify.Rename}
+		d.add(move)
+		checkEmitted(t, emitted, move)
+
+		d.flush()
+		have := make(map[string]fsnotify.Op)
+		for _, c := range *emitted {
+			have[c.path] = c.op
+		}
+		want := map[string]fsnotify.Op{
+			"/w/dir2/c":    fsnotify.Write,
+			"/w/new/a":     fsnotify.Create,
+			"/w/new/sub/b": fsnotify.Create,
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("after the move:\nhave %v\nwant %v", have, want)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"RSKGroup/OPIe/utils/symlink"
+	"github.com/fsnotify/fsnotify"
+)
+
+// renameTracker pairs the old name of a rename, which arrives as a Rename
+// event, with its new name, which arrives as a Create. inotify pairs them by
+// their cookie. Elsewhere a new path is taken as a renamed one when it is the
+// same file, by device, inode and size, as a path renamed within the window,
+// which needs the identity of every watched path remembered.
+type renameTracker struct {
+	window  time.Duration
+	pending map[string]time.Time
+	// nil when the backend pairs renames itself
+	ids map[string]fileIdentity
+}
+
+// fileIdentity is what a renamed file is recognised by
+type fileIdentity struct {
+	id   symlink.FileID
+	size int64
+}
+
+func newRenameTracker(window time.Duration) *renameTracker {
+	r := &renameTracker{
+		window:  window,
+		pending: make(map[string]time.Time),
+	}
+	// The inotify backend sets Event.RenamedFrom
+	if runtime.GOOS != "linux" {
+		r.ids = make(map[string]fileIdentity)
+	}
+	return r
+}
+
+// remember records the identity of a watched path, when it is needed
+func (r *renameTracker) remember(path string, info os.FileInfo) {
+	if r.ids == nil {
+		return
+	}
+	if id, ok := symlink.ID(info); ok {
+		r.ids[path] = fileIdentity{id: id, size: info.Size()}
+	}
+}
+
+// forget drops a path that is gone, and everything that was below it
+func (r *renameTracker) forget(path string) {
+	for known := range r.ids {
+		if isUnder(known, path) {
+			delete(r.ids, known)
+		}
+	}
+}
+
+// renamed holds back the old name of a rename until its new name arrives
+func (r *renameTracker) renamed(path string) {
+	r.pending[path] = time.Now()
+}
+
+// match returns the old name of a rename a Create event completes, or ""
+// when the path is new. The old name is no longer pending afterwards.
+func (r *renameTracker) match(event fsnotify.Event, info os.FileInfo) string {
+	from := event.RenamedFrom
+	if from == "" && r.ids != nil {
+		if id, ok := symlink.ID(info); ok {
+			want := fileIdentity{id: id, size: info.Size()}
+			for path := range r.pending {
+				if r.ids[path] == want {
+					from = path
+					break
+				}
+			}
+		}
+	}
+	if _, ok := r.pending[from]; !ok {
+		// Moved in from outside the watched paths
+		return ""
+	}
+	delete(r.pending, from)
+	r.moved(from, event.Name)
+	return from
+}
+
+// moved carries the identities below a renamed path over to its new name
+func (r *renameTracker) moved(oldPath, newPath string) {
+	for known, identity := range r.ids {
+		if isUnder(known, oldPath) {
+			delete(r.ids, known)
+			r.ids[newPath+known[len(oldPath):]] = identity
+		}
+	}
+}
+
+// expired returns the old names whose new name didn't arrive within the
+// window, or every pending one when all is set; they were renamed away
+// from the watched paths, and are gone as far as the index is concerned
+func (r *renameTracker) expired(all bool) []string {
+	var gone []string
+	for path, at := range r.pending {
+		if all || time.Since(at) >= r.window {
+			gone = append(gone, path)
+			delete(r.pending, path)
+			r.forget(path)
+		}
+	}
+	return gone
+}
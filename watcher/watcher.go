@@ -3,41 +3,63 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"RSKGroup/OPIe/utils/indexer"
+	"RSKGroup/OPIe/utils/pathFilter"
 	"github.com/fsnotify/fsnotify"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var watcher *fsnotify.Watcher
 var paths []string
-var fileCollection *mongo.Collection
-
-func init() {
-	confPath := flag.String("conf", "conf.json", "Path to the configuration file")
-	flag.Parse()
-
-	loadConfig(*confPath)
-}
-
+var filters []*pathFilter.Filter
+var rootPath string
+var index *indexer.Indexer
+var workers int
+var renameWindow time.Duration
+
+// The inotify backend watches a directory and everything below it itself,
+// including directories created or moved in later
+var nativeRecursion = runtime.GOOS == "linux"
+var debounceQuiet, debounceMaxWait time.Duration
+var debounceMaxPending int
+var deepHashEvery time.Duration
+
+// Configuration is conf.json. The indexing settings are the builder's,
+// shared through indexer.Options.
 type Configuration struct {
-	DbType   string   `json:"DbType"`
-	Host     string   `json:"Host"`
-	Port     string   `json:"Port"`
-	DbUser   string   `json:"DbUser"`
-	DbPwd    string   `json:"DbPwd"`
-	DbName   string   `json:"DbName"`
-	FileColl string   `json:"FileColl"`
-	TreeColl string   `json:"TreeColl"`
-	NoExif   []string `json:"NoExif"`
-	Watcher  []string `json:"Watcher"`
+	// Root of the document ancestry, as in the builder; each watched
+	// directory is its own root when it isn't set or doesn't hold it
+	Root    string   `json:"root"`
+	Watcher []string `json:"Watcher"`
+	// Goroutines indexing changed files; defaults to the number of CPUs
+	WatchWorkers int `json:"WatchWorkers"`
+	// How long the old name of a rename waits for its new name before it is
+	// taken as moved away and removed; defaults to 500
+	RenameWindowMs int `json:"RenameWindowMs"`
+	// A path's events are applied once none has arrived for it for
+	// DebounceQuietMs (200 by default), or once it has waited
+	// DebounceMaxWaitMs (2000 by default); at most DebounceMaxPending paths
+	// (10000 by default) wait at a time
+	DebounceQuietMs    int `json:"DebounceQuietMs"`
+	DebounceMaxWaitMs  int `json:"DebounceMaxWaitMs"`
+	DebounceMaxPending int `json:"DebounceMaxPending"`
+	// How often the full hashes of files that only have a quick fingerprint
+	// are filled in, in the background; 0 leaves them to builder -deephash
+	DeepHashMinutes int `json:"DeepHashMinutes"`
+	indexer.Options
+	// Include, Exclude, MaxDepth, MinSize, MaxSize, SkipHidden and IgnoreFile
+	pathFilter.Config
 }
 
 func loadConfig(path string) {
@@ -56,24 +78,63 @@ func loadConfig(path string) {
 
 	// Set the watch directories
 	paths = config.Watcher
+	rootPath = config.Root
+	workers = config.WatchWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	renameWindow = time.Duration(config.RenameWindowMs) * time.Millisecond
+	if renameWindow <= 0 {
+		renameWindow = 500 * time.Millisecond
+	}
+	debounceQuiet = time.Duration(config.DebounceQuietMs) * time.Millisecond
+	if debounceQuiet <= 0 {
+		debounceQuiet = 200 * time.Millisecond
+	}
+	debounceMaxWait = time.Duration(config.DebounceMaxWaitMs) * time.Millisecond
+	if debounceMaxWait <= 0 {
+		debounceMaxWait = 2 * time.Second
+	}
+	debounceMaxPending = config.DebounceMaxPending
+	if debounceMaxPending <= 0 {
+		debounceMaxPending = 10000
+	}
+	deepHashEvery = time.Duration(config.DeepHashMinutes) * time.Minute
 
-	// Configure MongoDB connection
-	dbType := config.DbType
-	host := config.Host
-	port := config.Port
-	dbUser := config.DbUser
-	dbPwd := config.DbPwd
-	dbName := config.DbName
+	// Each watched directory is the root of its own include/exclude rules
+	for _, path := range paths {
+		filter, err := pathFilter.New(path, config.Config)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		filter.OnError = func(path string, err error) {
+			log.Println("Error reading ignore file:", path, err)
+		}
+		filters = append(filters, filter)
+	}
 
-	// Connect to MongoDB
-	err = connectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName)
+	// Open the index, sharing one database connection and exiftool pool
+	// between every change indexed
+	index, err = indexer.New(config.Options)
 	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+		log.Fatalf("Failed to open index: %v", err)
+	}
+	index.Incremental = true
+	if _, ok := index.Sink().(indexer.IndexLookup); !ok {
+		log.Printf("Sink %q can't look documents up; every change is hashed again", config.Sink)
 	}
+	if _, ok := index.Sink().(indexer.IndexMover); !ok {
+		log.Printf("Sink %q can't move documents; renamed paths are removed and indexed again", config.Sink)
+	}
+	index.StartRun(indexer.NewRunID(time.Now()))
 }
 
 // main
 func main() {
+	confPath := flag.String("conf", "conf.json", "Path to the configuration file")
+	flag.Parse()
+	loadConfig(*confPath)
+
 	// create your file with desired read/write permissions
 	f, err := os.OpenFile("tracelog.log", os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
@@ -83,6 +144,15 @@ func main() {
 
 	// set output of logs to f
 	log.SetOutput(f)
+	defer func() {
+		if counter, ok := index.Sink().(indexer.IndexCounter); ok {
+			documents, versions, treeNodes := counter.Counts()
+			log.Printf("%d documents, %d file versions and %d tree nodes in the index\n", documents, versions, treeNodes)
+		}
+		if err := index.Close(); err != nil {
+			log.Println("Error closing index:", err)
+		}
+	}()
 
 	// creates a new file watcher
 	watcher, err = fsnotify.NewWatcher()
@@ -92,87 +162,336 @@ func main() {
 	defer watcher.Close()
 
 	// watch the specified directories
-	for _, path := range paths {
-		err := watchDir(path)
+	renames := newRenameTracker(renameWindow)
+	for i, path := range paths {
+		var err error
+		if nativeRecursion {
+			// Excluded directories aren't watched, as they come and go too
+			filter := filters[i]
+			err = watcher.AddWith(filepath.Join(path, "..."), fsnotify.WithSkipDir(func(dir string) bool {
+				info, err := os.Lstat(dir)
+				return err == nil && !filter.AllowPath(dir, info)
+			}))
+		} else {
+			err = watchDir(path, filters[i], renames.remember)
+		}
 		if err != nil {
 			log.Println("ERROR", err)
 		}
 	}
 
-	done := make(chan bool)
+	// Changes are applied by a pool of workers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := newWorkerPool(workers, func(c change) { apply(ctx, c) })
+	// Bursts of events for a path settle into one change before they are
+	// queued
+	debounce := newDebouncer(debounceQuiet, debounceMaxWait, debounceMaxPending, pool.dispatch)
+	enqueue := debounce.add
+
+	// Files that only have a quick fingerprint get their full hash in the
+	// background; a pass under way stops when the watcher does
+	deepHashCtx, stopDeepHash := context.WithCancel(ctx)
+	deepHashDone := deepHashInBackground(deepHashCtx, deepHashEvery)
+	defer func() {
+		stopDeepHash()
+		<-deepHashDone
+	}()
 
-	go func() {
-		for {
-			select {
-			case event := <-watcher.Events:
-				// Handle the events
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					file, err := os.Stat(event.Name)
-					if err != nil {
-						log.Println("Error getting file info:", err)
-						continue
+	// Documents are moved along with a renamed path when the sink can,
+	// rather than removed and indexed again under the new name
+	_, canMove := index.Sink().(indexer.IndexMover)
+	// Pending renames and held changes are checked on every tick
+	interval := renameWindow
+	if debounceQuiet < interval {
+		interval = debounceQuiet
+	}
+	tick := time.NewTicker(interval / 4)
+	defer tick.Stop()
+
+	// Stop on SIGINT/SIGTERM, once the changes already queued are applied
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case event := <-watcher.Events:
+			filter := filterFor(event.Name)
+			if filter == nil {
+				continue
+			}
+			if filter.IsIgnoreFile(event.Name) {
+				// Pick up the changed rules next time they are needed
+				filter.Forget(filepath.Dir(event.Name))
+			}
+			log.Println(event)
+
+			// Handle the events
+			switch {
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				unwatchDir(event.Name)
+				if event.Op&fsnotify.Rename == fsnotify.Rename {
+					// Held back until its new name arrives as a Create, or
+					// taken as gone once the window is over
+					renames.renamed(event.Name)
+					continue
+				}
+				renames.forget(event.Name)
+				enqueue(change{path: event.Name, op: fsnotify.Remove})
+			case event.Op&fsnotify.Create == fsnotify.Create:
+				file, err := os.Lstat(event.Name)
+				if err != nil {
+					// Already gone again; its Remove follows
+					continue
+				}
+				from := renames.match(event, file)
+				if !filter.AllowPath(event.Name, file) {
+					if from != "" {
+						enqueue(change{path: from, op: fsnotify.Remove})
 					}
-					if !file.IsDir() {
-						fileInfo := struct {
-							Root string `json:"Root"`
-							Name string `json:"Name"`
-							Date string `json:"Date"`
-						}{
-							Root: filepath.Dir(event.Name),
-							Name: event.Name,
-							Date: file.ModTime().String(),
-						}
-						fileInfoJSON, err := json.Marshal(fileInfo)
-						if err != nil {
-							log.Println("Error marshaling JSON:", err)
-							continue
-						}
-						log.Println(string(fileInfoJSON))
-
-						// Execute r3_builder.go as a separate process
-						cmd := exec.Command("go", "run", filepath.Join("builder", "4builder.go"), "-path", event.Name)
-						cmd.Dir = "/"
-						err = cmd.Run()
-						if err != nil {
-							log.Println("Error executing r3_builder.go:", err)
-						}
-					} else {
-						dirInfo := struct {
-							Root string `json:"Root"`
-							Name string `json:"Name"`
-							Date string `json:"Date"`
-						}{
-							Root: filepath.Dir(event.Name),
-							Name: event.Name,
-							Date: file.ModTime().String(),
-						}
-						dirInfoJSON, err := json.Marshal(dirInfo)
-						if err != nil {
-							log.Println("Error marshaling JSON:", err)
-							continue
-						}
-						log.Println(string(dirInfoJSON))
+					continue
+				}
+				renames.remember(event.Name, file)
 
-						// Insert document into MongoDB
-						_, err = fileCollection.InsertOne(context.TODO(), dirInfo)
+				if from != "" && canMove {
+					enqueue(change{path: event.Name, from: from, op: fsnotify.Rename})
+					if file.IsDir() && !nativeRecursion {
+						// Its documents move with it; only the watches are new
+						err := watchDir(event.Name, filter, renames.remember)
 						if err != nil {
-							log.Println("Error inserting document into MongoDB:", err)
+							log.Println("ERROR", err)
 						}
 					}
+					continue
+				}
+				if from != "" {
+					enqueue(change{path: from, op: fsnotify.Remove})
+				}
+				enqueue(change{path: event.Name, op: fsnotify.Create})
+				if file.IsDir() && !nativeRecursion {
+					// Watch the new directory and index what was put in it
+					// before the watch was there. With native recursion the
+					// backend does both, sending a Create for each.
+					err := watchDir(event.Name, filter, func(path string, info os.FileInfo) {
+						renames.remember(path, info)
+						enqueue(change{path: path, op: fsnotify.Create})
+					})
+					if err != nil {
+						log.Println("ERROR", err)
+					}
+				}
+			case event.Op&fsnotify.Write == fsnotify.Write:
+				file, err := os.Lstat(event.Name)
+				if err != nil {
+					continue
+				}
+				// A directory's own writes are its entries changing, which
+				// arrive as events of their own
+				if file.IsDir() || !filter.AllowPath(event.Name, file) {
+					continue
+				}
+				renames.remember(event.Name, file)
+				enqueue(change{path: event.Name, op: fsnotify.Write})
+			case event.Op&fsnotify.Chmod == fsnotify.Chmod:
+				file, err := os.Lstat(event.Name)
+				if err != nil || !filter.AllowPath(event.Name, file) {
+					continue
 				}
+				enqueue(change{path: event.Name, op: fsnotify.Chmod})
+			}
+		case err := <-watcher.Errors:
+			log.Println("ERROR", err)
+		case <-tick.C:
+			for _, path := range renames.expired(false) {
+				enqueue(change{path: path, op: fsnotify.Remove})
+			}
+			debounce.settle()
+		case sig := <-signals:
+			log.Println("Stopping on", sig)
+			for _, path := range renames.expired(true) {
+				enqueue(change{path: path, op: fsnotify.Remove})
+			}
+			debounce.flush()
+			pool.close()
+			return
+		}
+	}
+}
 
-				// ... Handle other events if needed ...
-			case err := <-watcher.Errors:
-				log.Println("ERROR", err)
+// change is a path to bring up to date in the index, and what happened to
+// it. A Rename is a move from the path in from. barrier is set by the worker
+// pool on a move and on what holds the other workers meanwhile.
+type change struct {
+	path    string
+	from    string
+	op      fsnotify.Op
+	barrier *barrier
+}
+
+// apply brings the index up to date with a change. The path is looked at
+// again first, as later events for it may already be queued behind this one.
+// Failures are logged, and added to the error report by the indexer.
+func apply(ctx context.Context, c change) {
+	if c.op == fsnotify.Rename {
+		// Whatever happened to the new name since is queued behind this
+		count, err := index.Move(ctx, c.from, c.path, rootFor(c.path))
+		if err != nil {
+			log.Println("Error moving in index:", c.from, c.path, err)
+			return
+		}
+		log.Println("Moved", count, "documents from", c.from, "to", c.path)
+		updateAncestors(ctx, c.from)
+		updateAncestors(ctx, c.path)
+		return
+	}
+
+	fileInfo, err := os.Lstat(c.path)
+	if c.op == fsnotify.Remove {
+		if err == nil {
+			// It is back already; the Create that followed indexes it
+			return
+		}
+		count, err := index.Remove(ctx, c.path, false)
+		if err != nil {
+			log.Println("Error removing from index:", c.path, err)
+			return
+		}
+		log.Println("Removed", count, "documents for", c.path)
+		updateAncestors(ctx, c.path)
+		return
+	}
+	if err != nil {
+		// Gone since; the Remove that follows tombstones it
+		return
+	}
+
+	if c.op == fsnotify.Chmod {
+		refreshed, err := index.RefreshMode(ctx, c.path, fileInfo)
+		if err != nil {
+			log.Println("Error refreshing file mode:", c.path, err)
+		}
+		if refreshed || err != nil {
+			return
+		}
+	}
+
+	// A directory's totals need a walk of what is below it
+	var totals indexer.DirectoryTotals
+	if fileInfo.IsDir() {
+		totals = directoryTotals(c.path, filterFor(c.path))
+	}
+	if err := index.IndexPath(ctx, c.path, rootFor(c.path), fileInfo, totals); err == nil {
+		updateAncestors(ctx, c.path)
+	}
+}
+
+// updateAncestors brings the totals of the directories above a changed path
+// up to date, when the sink can recount them
+func updateAncestors(ctx context.Context, path string) {
+	err := index.UpdateAncestors(ctx, path, rootFor(path))
+	if err != nil && !errors.Is(err, indexer.ErrNotSupported) {
+		log.Println("Error updating directory totals:", path, err)
+	}
+}
+
+// deepHashInBackground fills in the full hashes of files that only have a
+// quick fingerprint now and every interval after, one file at a time so
+// changes keep being applied promptly. The returned channel is closed once
+// it has stopped after ctx is cancelled, straight away if there is nothing
+// to do.
+func deepHashInBackground(ctx context.Context, every time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	rehasher, ok := index.Sink().(indexer.IndexRehasher)
+	if every <= 0 || !ok {
+		if every > 0 {
+			log.Println("The sink can't deep hash; DeepHashMinutes is ignored")
+		}
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			count, err := rehasher.DeepHash(ctx, index, 1)
+			if err != nil && ctx.Err() == nil {
+				log.Println("Error deep hashing:", err)
+			} else if count > 0 {
+				log.Println("Deep hashed", count, "files")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
 			}
 		}
 	}()
+	return done
+}
+
+// directoryTotals walks a directory to total what is below it, skipping what
+// the filter leaves out as the builder does
+func directoryTotals(path string, filter *pathFilter.Filter) indexer.DirectoryTotals {
+	var totals indexer.DirectoryTotals
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		log.Println("Error reading directory:", err)
+		return totals
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		info, err := entry.Info()
+		if err != nil || (filter != nil && !filter.Allow(entryPath, info)) {
+			continue
+		}
+		var child indexer.DirectoryTotals
+		if info.IsDir() {
+			child = directoryTotals(entryPath, filter)
+		}
+		totals.Add(info, child)
+	}
+	return totals
+}
+
+// rootFor returns the root a path's ancestry is recorded up to
+func rootFor(path string) string {
+	if rootPath != "" && isUnder(path, rootPath) {
+		return rootPath
+	}
+	if filter := filterFor(path); filter != nil {
+		return filter.Root()
+	}
+	return filepath.Dir(path)
+}
+
+// filterFor returns the filter of the watched directory a path is in, or nil
+func filterFor(path string) *pathFilter.Filter {
+	var found *pathFilter.Filter
+	for _, filter := range filters {
+		root := filter.Root()
+		if !isUnder(path, root) {
+			continue
+		}
+		// Nested watched directories use the innermost one's rules
+		if found == nil || len(root) > len(found.Root()) {
+			found = filter
+		}
+	}
+	return found
+}
 
-	<-done
+// isUnder reports whether path is dir or inside it
+func isUnder(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
 }
 
-// watchDir gets run as a walk func, searching for directories to add watchers to
-func watchDir(path string) error {
+// watchDir gets run as a walk func, searching for directories to add watchers to.
+// Unless found is nil, it is called with every path below the directory.
+// It is only needed without native recursion.
+func watchDir(path string, filter *pathFilter.Filter, found func(path string, info os.FileInfo)) error {
 	// Add watcher for the current directory
 	if err := watcher.Add(path); err != nil {
 		log.Println("Error adding watcher to directory:", err)
@@ -197,9 +516,16 @@ func watchDir(path string) error {
 	}
 
 	for _, file := range files {
+		subPath := filepath.Join(path, file.Name())
+		// Excluded paths aren't watched or indexed, nor anything below them
+		if !filter.Allow(subPath, file) {
+			continue
+		}
+		if found != nil {
+			found(subPath, file)
+		}
 		if file.IsDir() {
-			subDirPath := filepath.Join(path, file.Name())
-			if err := watchDir(subDirPath); err != nil {
+			if err := watchDir(subPath, filter, found); err != nil {
 				log.Println("Error adding watcher to subdirectory:", err)
 			}
 		}
@@ -208,28 +534,17 @@ func watchDir(path string) error {
 	return nil
 }
 
-func connectToMongoDB(dbType, host, port, dbUser, dbPwd, dbName string) error {
-	// Construct MongoDB connection URI
-	mongodbURI := dbType + "://" + dbUser + ":" + dbPwd + "@" + host + ":" + port
-
-	// Configure the client connection
-	clientOptions := options.Client().ApplyURI(mongodbURI)
-
-	// Connect to MongoDB
-	client, err := mongo.Connect(context.Background(), clientOptions)
-	if err != nil {
-		return fmt.Errorf("failed to connect to MongoDB: %v", err)
+// unwatchDir removes the watches on a directory that was removed or renamed
+// away and on every directory that was below it. inotify drops a deleted
+// directory's own watch, but a renamed one would keep reporting under its
+// old name. With native recursion the backend keeps its watches up to date.
+func unwatchDir(path string) {
+	if nativeRecursion {
+		return
 	}
-
-	// Check if the connection was successful
-	err = client.Ping(context.Background(), nil)
-	if err != nil {
-		return fmt.Errorf("failed to ping MongoDB: %v", err)
+	for _, watched := range watcher.WatchList() {
+		if isUnder(watched, path) {
+			watcher.Remove(watched)
+		}
 	}
-
-	// Access the specified database and collection
-	db := client.Database(dbName)
-	fileCollection = db.Collection("watcher")
-
-	return nil
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// workerPool applies changes on a fixed set of goroutines, so indexing
+// doesn't hold up the event loop. Each path always goes to the same worker,
+// so its changes are applied in the order they happened.
+//
+// A move touches everything below both of its names, which other workers may
+// have changes for, so it is applied on its own: every change queued before
+// it is applied first, and none queued after it starts until it is done.
+type workerPool struct {
+	queues []chan change
+	wg     sync.WaitGroup
+}
+
+// barrier holds every worker but the one applying a move until it is done
+type barrier struct {
+	// Workers yet to apply everything queued before the move
+	arrived sync.WaitGroup
+	done    chan struct{}
+}
+
+func newWorkerPool(workers int, apply func(change)) *workerPool {
+	p := &workerPool{queues: make([]chan change, workers)}
+	for i := range p.queues {
+		p.queues[i] = make(chan change, 1000)
+		p.wg.Add(1)
+		go func(queue chan change) {
+			defer p.wg.Done()
+			for c := range queue {
+				switch {
+				case c.barrier == nil:
+					apply(c)
+				case c.op == fsnotify.Rename:
+					c.barrier.arrived.Wait()
+					apply(c)
+					close(c.barrier.done)
+				default:
+					c.barrier.arrived.Done()
+					<-c.barrier.done
+				}
+			}
+		}(p.queues[i])
+	}
+	return p
+}
+
+// dispatch queues a change on its path's worker. A move is queued with a
+// barrier on every other worker.
+func (p *workerPool) dispatch(c change) {
+	hash := fnv.New32a()
+	hash.Write([]byte(c.path))
+	owner := int(hash.Sum32() % uint32(len(p.queues)))
+	if c.op != fsnotify.Rename || len(p.queues) == 1 {
+		p.queues[owner] <- c
+		return
+	}
+
+	b := &barrier{done: make(chan struct{})}
+	b.arrived.Add(len(p.queues) - 1)
+	for i, queue := range p.queues {
+		if i == owner {
+			c.barrier = b
+			queue <- c
+		} else {
+			queue <- change{barrier: b}
+		}
+	}
+}
+
+// close applies everything queued and stops the workers
+func (p *workerPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
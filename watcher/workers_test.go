@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A move should be applied after every change queued before it, whichever
+// worker they went to, and before every change queued after it.
+func TestWorkerPoolMove(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		applied []change
+	)
+	pool := newWorkerPool(4, func(c change) {
+		if c.op == fsnotify.Write {
+			// Keep the workers busy so a move would overtake them
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		applied = append(applied, c)
+		mu.Unlock()
+	})
+
+	var before, after []string
+	for i := 0; i < 50; i++ {
+		before = append(before, fmt.Sprintf("/w/dir/%d", i))
+		after = append(after, fmt.Sprintf("/w/new/%d", i))
+	}
+	for _, path := range before {
+		pool.dispatch(change{path: path, op: fsnotify.Write})
+	}
+	pool.dispatch(change{path: "/w/new", from: "/w/dir", op: fsnotify.Rename})
+	for _, path := range after {
+		pool.dispatch(change{path: path, op: fsnotify.Write})
+	}
+	pool.close()
+
+	if len(applied) != len(before)+1+len(after) {
+		t.Fatalf("applied %d changes, want %d", len(applied), len(before)+1+len(after))
+	}
+	moved := -1
+	for i, c := range applied {
+		if c.op == fsnotify.Rename {
+			moved = i
+		}
+	}
+	if moved != len(before) {
+		t.Errorf("move applied after %d changes, want %d", moved, len(before))
+	}
+	for i, c := range applied {
+		if i < moved && !isUnder(c.path, "/w/dir") || i > moved && !isUnder(c.path, "/w/new") {
+			t.Errorf("%s applied out of turn at %d", c.path, i)
+		}
+	}
+}